@@ -4,68 +4,91 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"os"
 
 	"bytes"
-	"net/url"
 
 	"io/ioutil"
 
 	"github.com/SierraSoftworks/inki/crypto"
 	log "github.com/Sirupsen/logrus"
 	"github.com/urfave/cli"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/clearsign"
-	"golang.org/x/crypto/ssh/terminal"
+	sshagent "golang.org/x/crypto/ssh/agent"
 )
 
 var addKeyCommand = cli.Command{
 	Name:      "add",
 	Usage:     "Adds an SSH key to the Inki key server",
 	UsageText: "user@inki-server",
-	Flags: []cli.Flag{
-		cli.StringFlag{
-			Name:  "pgp-key, p",
-			Usage: "The PGP private key you wish to use to sign this request",
-		},
+	Flags: append(append([]cli.Flag{
+		audienceFlag,
 		cli.StringFlag{
 			Name:  "file, f",
 			Usage: "The SSH public key file which you would like to submit",
 		},
+		cli.StringFlag{
+			Name:  "certificate, C",
+			Usage: "Write the SSH certificate issued by the server to this file, for example ~/.ssh/id_ed25519-cert.pub",
+		},
+		cli.StringSliceFlag{
+			Name:  "option, o",
+			Usage: "An authorized_keys option to apply to this key, for example 'command=\"/bin/true\"' or 'no-pty'",
+		},
+		cli.StringSliceFlag{
+			Name:  "scope-host",
+			Usage: "Only serve this key to the named host, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "scope-group",
+			Usage: "Only serve this key to hosts in the named group, may be repeated",
+		},
 		cli.DurationFlag{
 			Name:  "expire, x",
 			Usage: "The amount of time that the key should be valid for",
 			Value: time.Hour,
 		},
-	},
+	}, append(generateFlags, signingFlags...)...), transportFlags...),
 	Before: func(c *cli.Context) error {
 		log.SetOutput(os.Stderr)
 		return nil
 	},
 	Action: func(c *cli.Context) error {
-		if c.NArg() < 1 {
-			return fmt.Errorf("Missing user and host argument")
-		}
-
-		u, err := url.Parse(c.Args().First())
+		u, err := parseTarget(c)
 		if err != nil {
-			log.WithError(err).Error("Failed to parse host URL")
-			return fmt.Errorf("Failed to parse user and host argument")
+			return err
 		}
 
-		if u.User == nil || u.User.String() == "" {
-			log.Error("Host URL did not contain a username")
-			return fmt.Errorf("Host address did not contain a username")
-		}
-
-		if u.Scheme == "" {
-			u.Scheme = "http"
-		}
+		var generated *generatedKey
+		var keyAgent sshagent.ExtendedAgent
 
 		keyData := bytes.NewBuffer([]byte{})
-		if c.IsSet("file") {
+		if c.Bool("generate") {
+			if err := checkGenerateFlags(c); err != nil {
+				return err
+			}
+
+			// The agent is connected to before the key is submitted so that
+			// it isn't registered if it can't be used.
+			if c.Bool("add-to-agent") {
+				keyAgent, err = dialAgent()
+				if err != nil {
+					return err
+				}
+			}
+
+			generated, err = generateKey(fmt.Sprintf("inki:%s@%s", u.User.Username(), u.Host))
+			if err != nil {
+				log.
+					WithError(err).
+					Debug("Failed to generate key")
+				return fmt.Errorf("Failed to generate a new key")
+			}
+
+			keyData = bytes.NewBufferString(generated.AuthorizedKey())
+		} else if c.IsSet("file") {
 			kd, err := ioutil.ReadFile(c.String("file"))
 			if err != nil {
 				log.
@@ -90,71 +113,28 @@ var addKeyCommand = cli.Command{
 			User:      u.User.Username(),
 			PublicKey: keyData.String(),
 			Expires:   time.Now().Add(c.Duration("expire")),
+			Options:   c.StringSlice("option"),
+			Hosts:     c.StringSlice("scope-host"),
+			Groups:    c.StringSlice("scope-group"),
 		}
 
-		p, err := ioutil.ReadFile(c.String("pgp-key"))
-		if err != nil {
+		if err := key.Validate(); err != nil {
 			log.
 				WithError(err).
-				WithField("file", c.String("pgp-key")).
-				Debug("Failed to read the pgp-key file")
-			return fmt.Errorf("Failed to read the pgp-key you provided")
+				Debug("Key failed validation")
+			return fmt.Errorf("The key you provided is not valid: %s", err)
 		}
 
-		kr, err := openpgp.ReadArmoredKeyRing(bytes.NewBuffer(p))
+		signer, err := loadSigner(c, c.IsSet("file") || generated != nil)
 		if err != nil {
-			log.WithError(err).
-				WithField("file", c.String("pgp-key")).
-				Debug("Failed to decode the pgp-key file")
-			return fmt.Errorf("Failed to decode the pgp-key you provided")
-		}
-
-		pk := kr[0].PrivateKey
-		if pk.Encrypted {
-			if !c.IsSet("file") {
-				log.
-					Debug("Private key is encrypted and stdin has been used to read the SSH key")
-				return fmt.Errorf("Private key is encrypted and stdin was used to read the SSH key")
-			}
-
-			fmt.Print("Enter PGP key password: ")
-			pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-			fmt.Println()
-			if err != nil {
-				log.
-					WithError(err).
-					Debug("Failed to request password from user")
-				return fmt.Errorf("Failed to request password input")
-			}
-
-			err = pk.Decrypt(pw)
-			if err != nil {
-				log.
-					WithError(err).
-					Debug("Failed to decrypt the PGP private key")
-				return fmt.Errorf("Failed to decrypt the PGP private key, please check that your password is correct")
-			}
+			return err
 		}
 
-		reqData := bytes.NewBuffer([]byte{})
-		reqStream, err := clearsign.Encode(reqData, pk, nil)
+		reqData, err := signRequest(signer, key, requestAudience(c, u))
 		if err != nil {
-			log.
-				WithError(err).
-				Debug("Failed to prepare signing packet")
-			return fmt.Errorf("Failed to prepare signing packet")
-		}
-
-		err = json.NewEncoder(reqStream).Encode(key)
-		if err != nil {
-			log.
-				WithError(err).
-				Debug("Failed to encode key request")
-			return fmt.Errorf("Failed to encode key request")
+			return err
 		}
 
-		reqStream.Close()
-
 		url := fmt.Sprintf("%s://%s/api/v1/keys", u.Scheme, u.Host)
 		req, err := http.NewRequest("POST", url, reqData)
 		if err != nil {
@@ -170,7 +150,12 @@ var addKeyCommand = cli.Command{
 			"expire": key.Expires,
 		}).Info("Submitting new key for user")
 
-		res, err := http.DefaultClient.Do(req)
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
 		if err != nil {
 			log.
 				WithError(err).
@@ -188,7 +173,7 @@ var addKeyCommand = cli.Command{
 					"status": res.StatusCode,
 				}).
 				Debug("Failed to send key request to server")
-			return fmt.Errorf("Failed to send key request to server: %s", res.Status)
+			return responseError("Failed to send key request to server", res)
 		}
 
 		keys := []crypto.Key{}
@@ -199,14 +184,75 @@ var addKeyCommand = cli.Command{
 			return fmt.Errorf("Failed to parse response from server")
 		}
 
+		added := key
+		for i := range keys {
+			if keys[i].Equals(key) {
+				added = &keys[i]
+			}
+		}
+
+		if c.IsSet("certificate") {
+			cert := added.Certificate
+			if cert == "" {
+				log.Debug("The server did not issue a certificate for the key")
+				return fmt.Errorf("The server did not issue a certificate for your key, it may not be configured as a certificate authority or your key may be scoped to specific hosts or groups")
+			}
+
+			if err := ioutil.WriteFile(c.String("certificate"), []byte(cert+"\n"), 0644); err != nil {
+				log.
+					WithError(err).
+					WithField("file", c.String("certificate")).
+					Debug("Failed to write certificate file")
+				return fmt.Errorf("Failed to write the certificate to '%s'", c.String("certificate"))
+			}
+		}
+
+		if generated != nil && c.String("identity-file") != "" {
+			if err := generated.WriteIdentityFile(c.String("identity-file"), added.Certificate); err != nil {
+				log.
+					WithError(err).
+					WithField("file", c.String("identity-file")).
+					Debug("Failed to write identity file")
+				return fmt.Errorf("Failed to write the private key to '%s'", c.String("identity-file"))
+			}
+		}
+
+		if generated != nil && keyAgent != nil {
+			if err := generated.AddToAgent(keyAgent, added.Certificate, added.Expires); err != nil {
+				log.
+					WithError(err).
+					Debug("Failed to add key to ssh-agent")
+				return fmt.Errorf("Failed to add the private key to ssh-agent")
+			}
+		}
+
 		fmt.Println("Added keys:")
 		for _, k := range keys {
 			fmt.Printf(" - Username:     %s\n", k.User)
+			if k.PendingID != "" {
+				fmt.Printf("   Pending:      %s (waiting for approval)\n", k.PendingID)
+			}
 			fmt.Printf("   Fingerprint:  %s\n", k.Fingerprint())
 			fmt.Printf("   Expires:      %s\n", k.Expires)
+			if len(k.Options) > 0 {
+				fmt.Printf("   Options:      %s\n", strings.Join(k.Options, ","))
+			}
+			if k.Scoped() {
+				fmt.Printf("   Scope:        %s\n", strings.Join(append(k.Hosts, k.Groups...), ", "))
+			}
+			if k.Certificate != "" && c.IsSet("certificate") {
+				fmt.Printf("   Certificate:  %s\n", c.String("certificate"))
+			}
 			fmt.Println()
 		}
 
+		if generated != nil && added.PendingID != "" {
+			fmt.Printf("Your key is waiting for approval (%s) and can be used once it has been approved.\n", added.PendingID)
+		} else if generated != nil {
+			fmt.Println("Connect using:")
+			fmt.Printf("  %s\n", sshCommand(c, key.User))
+		}
+
 		return nil
 	},
 }
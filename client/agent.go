@@ -0,0 +1,274 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// keyChange mirrors the changes reported by the server's watch API
+type keyChange struct {
+	Revision uint64     `json:"revision"`
+	Type     string     `json:"type"`
+	Key      crypto.Key `json:"key"`
+}
+
+type watchResponse struct {
+	Revision uint64      `json:"revision"`
+	Reset    bool        `json:"reset"`
+	Changes  []keyChange `json:"changes"`
+}
+
+var AgentCommand = cli.Command{
+	Category:  "Host",
+	Name:      "agent",
+	Usage:     "Keeps a local cache of the keys which apply to this host up to date",
+	UsageText: "inki-server",
+	Flags: append(append([]cli.Flag{
+		hostConfigFlag,
+		cli.StringFlag{
+			Name:   "server, s",
+			Usage:  "The Inki server to fetch keys from, if it is not provided as an argument",
+			EnvVar: "INKI_SERVER",
+		},
+		cli.StringSliceFlag{
+			Name:  "user, u",
+			Usage: "A user whose keys should be cached, if none are provided then all users are cached",
+		},
+		cli.StringFlag{
+			Name:   "host, H",
+			Usage:  "The name of this host, used to select keys scoped to it if it doesn't authenticate with the server",
+			EnvVar: "INKI_HOST",
+		},
+		cli.DurationFlag{
+			Name:  "retry-interval",
+			Usage: "How long to wait before retrying when the server cannot be reached",
+			Value: 10 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:  "resync-interval",
+			Usage: "How often to fetch the full list of keys, in case changes were missed",
+			Value: time.Hour,
+		},
+	}, cacheFlags...), transportFlags...),
+	Before: func(c *cli.Context) error {
+		log.SetOutput(os.Stderr)
+		return loadHostConfig(c)
+	},
+	Action: func(c *cli.Context) error {
+		target := c.String("server")
+		if c.NArg() > 0 {
+			target = c.Args().First()
+		}
+
+		if target == "" {
+			return fmt.Errorf("Missing server argument")
+		}
+
+		server, err := url.Parse(target)
+		if err != nil {
+			log.WithError(err).Error("Failed to parse server URL")
+			return fmt.Errorf("Failed to parse server argument")
+		}
+
+		if server.Scheme == "" {
+			server.Scheme = "http"
+		}
+
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		a := &agent{
+			server: fmt.Sprintf("%s://%s", server.Scheme, server.Host),
+			host:   c.String("host"),
+			users:  map[string]bool{},
+			client: client,
+			file:   c.String("cache"),
+		}
+
+		for _, u := range c.StringSlice("user") {
+			a.users[u] = true
+		}
+
+		log.WithFields(log.Fields{
+			"server": a.server,
+			"cache":  a.file,
+		}).Info("Starting agent")
+
+		a.Run(c.Duration("retry-interval"), c.Duration("resync-interval"))
+		return nil
+	},
+}
+
+type agent struct {
+	server string
+	host   string
+	users  map[string]bool
+	client *http.Client
+	file   string
+
+	cache *keyCache
+}
+
+// Run keeps the cache up to date, fetching all of the keys and then watching
+// for changes to them. Errors are logged and retried, leaving the existing
+// cache in place so that it can continue to be used while the server is down.
+func (a *agent) Run(retryInterval, resyncInterval time.Duration) {
+	var lastSync time.Time
+	for {
+		if a.cache == nil || time.Since(lastSync) > resyncInterval {
+			if err := a.Sync(); err != nil {
+				log.WithError(err).WithField("server", a.server).Error("Failed to fetch keys from the server")
+				time.Sleep(retryInterval)
+				continue
+			}
+
+			lastSync = time.Now()
+		}
+
+		reset, err := a.Watch()
+		if err != nil {
+			log.WithError(err).WithField("server", a.server).Error("Failed to watch the server for changes")
+			time.Sleep(retryInterval)
+			continue
+		}
+
+		if reset {
+			log.WithField("server", a.server).Info("Changes were missed, fetching all keys again")
+			a.cache = nil
+		}
+	}
+}
+
+func (a *agent) url(path string, query url.Values) string {
+	if a.host != "" {
+		query.Set("host", a.host)
+	}
+
+	if len(a.users) == 1 {
+		for u := range a.users {
+			query.Set("user", u)
+		}
+	}
+
+	if len(query) == 0 {
+		return fmt.Sprintf("%s%s", a.server, path)
+	}
+
+	return fmt.Sprintf("%s%s?%s", a.server, path, query.Encode())
+}
+
+func (a *agent) wants(k *crypto.Key) bool {
+	return len(a.users) == 0 || a.users[k.User]
+}
+
+// Sync replaces the cache with the full list of keys from the server
+func (a *agent) Sync() error {
+	res, err := a.client.Get(a.url("/api/v1/keys", url.Values{}))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return responseError("Failed to get list of keys", res)
+	}
+
+	revision, err := strconv.ParseUint(res.Header.Get("X-Inki-Revision"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("The server did not provide a revision for its keys")
+	}
+
+	keys := []crypto.Key{}
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("Failed to parse response from server")
+	}
+
+	cache := &keyCache{
+		Server:   a.server,
+		Revision: revision,
+		Keys:     []crypto.Key{},
+	}
+
+	for _, k := range keys {
+		if a.wants(&k) {
+			cache.Keys = append(cache.Keys, k)
+		}
+	}
+
+	if err := writeCache(a.file, cache); err != nil {
+		log.WithError(err).WithField("file", a.file).Error("Failed to write cache")
+		return fmt.Errorf("Failed to write the cache to '%s'", a.file)
+	}
+
+	log.WithFields(log.Fields{
+		"revision": revision,
+		"keys":     len(cache.Keys),
+	}).Info("Fetched keys from the server")
+
+	a.cache = cache
+	return nil
+}
+
+// Watch waits for changes from the server and applies them to the cache. If
+// the server no longer has the changes since the cache was updated then reset
+// is returned and the cache should be fetched again.
+func (a *agent) Watch() (reset bool, err error) {
+	query := url.Values{}
+	query.Set("since", strconv.FormatUint(a.cache.Revision, 10))
+	query.Set("timeout", "60s")
+
+	res, err := a.client.Get(a.url("/api/v1/watch", query))
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return false, responseError("Failed to watch for changes", res)
+	}
+
+	var changes watchResponse
+	if err := json.NewDecoder(res.Body).Decode(&changes); err != nil {
+		return false, fmt.Errorf("Failed to parse response from server")
+	}
+
+	if changes.Reset {
+		return true, nil
+	}
+
+	for _, change := range changes.Changes {
+		if !a.wants(&change.Key) {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"revision":    change.Revision,
+			"type":        change.Type,
+			"user":        change.Key.User,
+			"fingerprint": change.Key.Fingerprint(),
+		}).Info("Applying change from the server")
+
+		a.cache.Apply(&change)
+	}
+
+	a.cache.Revision = changes.Revision
+	if len(changes.Changes) > 0 {
+		if err := writeCache(a.file, a.cache); err != nil {
+			log.WithError(err).WithField("file", a.file).Error("Failed to write cache")
+			return false, fmt.Errorf("Failed to write the cache to '%s'", a.file)
+		}
+	}
+
+	return false, nil
+}
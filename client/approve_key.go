@@ -0,0 +1,180 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var pendingKeysCommand = cli.Command{
+	Name:      "pending",
+	Usage:     "Lists the keys which are waiting to be approved",
+	UsageText: "user@inki-server",
+	Flags:     transportFlags,
+	Before: func(c *cli.Context) error {
+		log.SetOutput(os.Stderr)
+		return nil
+	},
+	Action: func(c *cli.Context) error {
+		u, err := parseTarget(c)
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("%s://%s/api/v1/pending", u.Scheme, u.Host)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			log.WithError(err).Error("Failed to prepare request for pending keys")
+			return fmt.Errorf("Failed to prepare request for pending keys")
+		}
+
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			log.WithError(err).Error("Failed to make request for pending keys")
+			return fmt.Errorf("Request for pending keys failed to server '%s'", u.Host)
+		}
+
+		if res.StatusCode != 200 {
+			log.WithFields(log.Fields{
+				"server": u.Host,
+				"status": res.StatusCode,
+			}).Error("Failed to get list of pending keys")
+			return responseError("Failed to get list of pending keys", res)
+		}
+
+		pending := []crypto.PendingKey{}
+		if err := json.NewDecoder(res.Body).Decode(&pending); err != nil {
+			log.WithError(err).Error("Failed to parse response from server")
+			return fmt.Errorf("Failed to parse response from server")
+		}
+
+		fmt.Println("Pending keys:")
+		for _, p := range pending {
+			if p.Key.User != u.User.Username() {
+				continue
+			}
+
+			printPendingKey(&p)
+		}
+
+		return nil
+	},
+}
+
+var approveKeyCommand = cli.Command{
+	Name:      "approve",
+	Usage:     "Approves a key which is waiting to be approved",
+	UsageText: "user@inki-server id",
+	Flags: append(append([]cli.Flag{
+		audienceFlag,
+	}, signingFlags...), transportFlags...),
+	Before: func(c *cli.Context) error {
+		log.SetOutput(os.Stderr)
+		return nil
+	},
+	Action: func(c *cli.Context) error {
+		u, err := parseTarget(c)
+		if err != nil {
+			return err
+		}
+
+		if c.NArg() < 2 {
+			return fmt.Errorf("Missing pending key ID argument")
+		}
+
+		approval := &crypto.Approval{
+			Approve: c.Args().Get(1),
+		}
+
+		signer, err := loadSigner(c, true)
+		if err != nil {
+			return err
+		}
+
+		reqData, err := signRequest(signer, approval, requestAudience(c, u))
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("%s://%s/api/v1/pending/%s/approve", u.Scheme, u.Host, approval.Approve)
+		req, err := http.NewRequest("POST", url, reqData)
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to prepare request")
+			return fmt.Errorf("Failed to prepare request to server")
+		}
+
+		log.WithField("id", approval.Approve).Info("Approving pending key")
+
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			log.
+				WithError(err).
+				WithFields(log.Fields{
+					"server": u.Host,
+				}).
+				Debug("Failed to send approval to server")
+			return fmt.Errorf("Failed to send approval to server '%s'", u.Host)
+		}
+
+		if res.StatusCode != 200 {
+			log.
+				WithFields(log.Fields{
+					"server": u.Host,
+					"status": res.StatusCode,
+				}).
+				Debug("Failed to send approval to server")
+			return responseError("Failed to send approval to server", res)
+		}
+
+		var p crypto.PendingKey
+		if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to parse response from server")
+			return fmt.Errorf("Failed to parse response from server")
+		}
+
+		if p.Approved() {
+			fmt.Println("Approved key, it is now active:")
+		} else {
+			fmt.Println("Approved key, it is waiting for further approvals:")
+		}
+
+		printPendingKey(&p)
+		return nil
+	},
+}
+
+func printPendingKey(p *crypto.PendingKey) {
+	fmt.Printf(" - ID:           %s\n", p.ID)
+	fmt.Printf("   Username:     %s\n", p.Key.User)
+	fmt.Printf("   Fingerprint:  %s\n", p.Key.Fingerprint())
+	fmt.Printf("   Expires:      %s\n", p.Key.Expires)
+	if len(p.Key.Options) > 0 {
+		fmt.Printf("   Options:      %s\n", strings.Join(p.Key.Options, ","))
+	}
+	if p.Key.Scoped() {
+		fmt.Printf("   Scope:        %s\n", strings.Join(append(p.Key.Hosts, p.Key.Groups...), ", "))
+	}
+	fmt.Printf("   Requested By: %s\n", p.RequestedBy)
+	fmt.Printf("   Approvals:    %d of %d\n", len(p.Approvals), p.Required)
+	fmt.Println()
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxAuthorizedKeysSize limits how much of the server's response is read
+const maxAuthorizedKeysSize = 1 << 20
+
+var AuthorizedKeysCommand = cli.Command{
+	Category:  "Host",
+	Name:      "authorized-keys",
+	Usage:     "Prints the authorized_keys for a user, for use as sshd's AuthorizedKeysCommand",
+	UsageText: "user",
+	Description: `Keys are fetched from the server if one is configured, otherwise (or if the server
+   cannot be reached) they are read from the agent's cache. Only valid key lines are ever
+   written to stdout, and the command exits with a non-zero status if no source of keys
+   is available so that sshd will ignore its output.
+
+   AuthorizedKeysCommand /usr/local/bin/inki authorized-keys --fingerprint %f %u`,
+	Flags: append(append([]cli.Flag{
+		hostConfigFlag,
+		cli.StringFlag{
+			Name:   "server, s",
+			Usage:  "The Inki server to fetch keys from, if not provided then only the agent's cache is used",
+			EnvVar: "INKI_SERVER",
+		},
+		cli.StringFlag{
+			Name:   "host, H",
+			Usage:  "The name of this host, used to select keys scoped to it if it doesn't authenticate with the server",
+			EnvVar: "INKI_HOST",
+		},
+		cli.StringSliceFlag{
+			Name:   "group, G",
+			Usage:  "The groups this host belongs to, used to select keys scoped to them from the cache",
+			EnvVar: "INKI_GROUPS",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "How long to wait for the server before falling back to the cache",
+			Value: 5 * time.Second,
+		},
+		cli.StringFlag{
+			Name:  "fingerprint, f",
+			Usage: "Only print the key with this fingerprint (sshd's %f token)",
+		},
+		cli.StringFlag{
+			Name:  "key-type, t",
+			Usage: "Only print keys of this type (sshd's %t token)",
+		},
+		cli.StringFlag{
+			Name:  "key, k",
+			Usage: "Only print the key with this base64 encoded public key (sshd's %k token)",
+		},
+	}, cacheFlags...), transportFlags...),
+	// sshd treats anything written to stdout as authorized keys, so usage
+	// errors must not print the command's help.
+	OnUsageError: func(c *cli.Context, err error, isSubcommand bool) error {
+		return err
+	},
+	Before: func(c *cli.Context) error {
+		log.SetOutput(os.Stderr)
+		return loadHostConfig(c)
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 1 {
+			return fmt.Errorf("Missing user argument")
+		}
+
+		user := c.Args().First()
+		filter := &keyFilter{
+			Fingerprint: c.String("fingerprint"),
+			KeyType:     c.String("key-type"),
+			Key:         c.String("key"),
+		}
+
+		var lines []string
+		var err error
+		if c.String("server") != "" {
+			lines, err = fetchAuthorizedKeys(c, user, filter)
+			if err != nil {
+				log.WithError(err).WithField("server", c.String("server")).Warn("Failed to fetch keys from the server, falling back to the cache")
+			}
+		}
+
+		if c.String("server") == "" || err != nil {
+			lines, err = readCachedAuthorizedKeys(c, user)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Output is only written once all of the keys are known, so that an
+		// error part way through can't leave sshd with a partial list.
+		b := bytes.NewBuffer([]byte{})
+		for _, line := range lines {
+			ok, err := filter.Matches(line)
+			if err != nil {
+				log.WithError(err).WithField("line", line).Warn("Ignoring invalid authorized key")
+				continue
+			}
+
+			if ok {
+				b.WriteString(line)
+				b.WriteString("\n")
+			}
+		}
+
+		_, err = io.Copy(os.Stdout, b)
+		return err
+	},
+}
+
+// keyFilter selects the keys matching the fingerprint, type and key which
+// sshd provides for the key being used to log in.
+type keyFilter struct {
+	Fingerprint string
+	KeyType     string
+	Key         string
+}
+
+// Matches parses the authorized_keys line and determines whether it matches
+// the filter, an error is returned if the line is not a valid key.
+func (f *keyFilter) Matches(line string) (bool, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return false, err
+	}
+
+	if f.KeyType != "" && pub.Type() != f.KeyType {
+		return false, nil
+	}
+
+	if f.Key != "" && base64.StdEncoding.EncodeToString(pub.Marshal()) != f.Key {
+		return false, nil
+	}
+
+	if f.Fingerprint != "" {
+		md5 := ssh.FingerprintLegacyMD5(pub)
+		switch f.Fingerprint {
+		case ssh.FingerprintSHA256(pub), md5, "MD5:" + md5:
+		default:
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// fetchAuthorizedKeys asks the server for the user's authorized keys, only
+// fetching the key which matches the filter if it identifies one.
+func fetchAuthorizedKeys(c *cli.Context, user string, filter *keyFilter) ([]string, error) {
+	server, err := neturl.Parse(c.String("server"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse server address")
+	}
+
+	if server.Scheme == "" {
+		server.Scheme = "http"
+	}
+
+	query := neturl.Values{}
+	if c.String("host") != "" {
+		query.Set("host", c.String("host"))
+	}
+
+	if filter.Fingerprint != "" {
+		query.Set("fingerprint", filter.Fingerprint)
+	} else if filter.Key != "" {
+		query.Set("key", filter.Key)
+	}
+
+	url := fmt.Sprintf("%s://%s/api/v1/user/%s/authorized_keys", server.Scheme, server.Host, neturl.PathEscape(user))
+	if len(query) > 0 {
+		url = fmt.Sprintf("%s?%s", url, query.Encode())
+	}
+
+	client, err := httpClient(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client may be shared, so the timeout is set on a copy of it
+	timed := *client
+	timed.Timeout = c.Duration("timeout")
+
+	res, err := timed.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, responseError("Failed to get authorized keys", res)
+	}
+
+	lines := []string{}
+	scanner := bufio.NewScanner(io.LimitReader(res.Body, maxAuthorizedKeysSize))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// readCachedAuthorizedKeys reads the user's authorized keys from the cache
+// which is maintained by the agent.
+func readCachedAuthorizedKeys(c *cli.Context, user string) ([]string, error) {
+	cache, err := readCache(c.String("cache"))
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"user":     user,
+		"revision": cache.Revision,
+		"updated":  cache.Updated,
+	}).Debug("Loaded keys from cache")
+
+	// Keys are only taken from the cache if they apply to this host, so that
+	// a cache which was shared or built for another host can't grant access
+	// with keys scoped elsewhere.
+	host, groups := c.String("host"), c.StringSlice("group")
+	if host == "" && len(groups) == 0 {
+		for _, k := range cache.Keys {
+			if k.User == user && k.Scoped() {
+				log.WithField("user", user).Warn("Ignoring the user's scoped keys in the cache because no host or groups are configured")
+				break
+			}
+		}
+	}
+
+	return cache.AuthorizedKeys(user, host, groups), nil
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var cacheFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "cache",
+		Usage:  "The file in which the agent keeps its copy of the keys",
+		Value:  "/var/lib/inki/cache.json",
+		EnvVar: "INKI_CACHE",
+	},
+}
+
+// keyCache is the agent's local copy of the keys which apply to this host
+type keyCache struct {
+	Server   string       `json:"server"`
+	Revision uint64       `json:"revision"`
+	Updated  time.Time    `json:"updated"`
+	Keys     []crypto.Key `json:"keys"`
+}
+
+// checkedCache is the format the cache is stored in on disk, the checksum is
+// the SHA-256 of the cache data. It only detects a cache which has been
+// corrupted, anybody who can write to the cache can also update its checksum.
+type checkedCache struct {
+	Cache    json.RawMessage `json:"cache"`
+	Checksum string          `json:"checksum"`
+}
+
+// Apply updates the cache with a change made on the server
+func (c *keyCache) Apply(change *keyChange) {
+	keys := []crypto.Key{}
+	for _, k := range c.Keys {
+		if !k.Equals(&change.Key) {
+			keys = append(keys, k)
+		}
+	}
+
+	if change.Type == "added" {
+		keys = append(keys, change.Key)
+	}
+
+	c.Keys = keys
+	c.Revision = change.Revision
+}
+
+// AuthorizedKeys returns the authorized_keys lines for the user's keys which
+// have not expired and which apply to the host, as a member of the groups.
+func (c *keyCache) AuthorizedKeys(user, host string, groups []string) []string {
+	lines := []string{}
+	for _, k := range c.Keys {
+		if k.User != user || !k.AppliesTo(host, groups) {
+			continue
+		}
+
+		if err := k.Validate(); err != nil {
+			continue
+		}
+
+		lines = append(lines, k.AuthorizedKey())
+	}
+
+	return lines
+}
+
+func cacheChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readCache loads the cache from disk, ensuring that it matches its checksum.
+func readCache(file string) (*keyCache, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).Debug("Failed to read cache")
+		return nil, fmt.Errorf("Failed to read the cache from '%s'", file)
+	}
+
+	var checked checkedCache
+	if err := json.Unmarshal(data, &checked); err != nil {
+		log.WithError(err).WithField("file", file).Debug("Failed to parse cache")
+		return nil, fmt.Errorf("The cache in '%s' is not valid", file)
+	}
+
+	if cacheChecksum(checked.Cache) != checked.Checksum {
+		return nil, fmt.Errorf("The cache in '%s' does not match its checksum", file)
+	}
+
+	var cache keyCache
+	if err := json.Unmarshal(checked.Cache, &cache); err != nil {
+		log.WithError(err).WithField("file", file).Debug("Failed to parse cache")
+		return nil, fmt.Errorf("The cache in '%s' is not valid", file)
+	}
+
+	return &cache, nil
+}
+
+// writeCache replaces the cache on disk, along with its checksum, keys which
+// have expired are removed first.
+func writeCache(file string, cache *keyCache) error {
+	keys := []crypto.Key{}
+	for _, k := range cache.Keys {
+		if k.Expires.After(time.Now()) {
+			keys = append(keys, k)
+		}
+	}
+	cache.Keys = keys
+	cache.Updated = time.Now().UTC()
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	checked, err := json.Marshal(&checkedCache{
+		Cache:    data,
+		Checksum: cacheChecksum(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	// The cache is written to a temporary file and renamed into place so that
+	// sshd never sees a partially written cache.
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".inki-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(checked); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+var generateFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "generate, g",
+		Usage: "Generate a new ed25519 key to submit instead of reading one",
+	},
+	cli.StringFlag{
+		Name:  "identity-file, I",
+		Usage: "Write the generated private key to this file, for use with ssh -i",
+	},
+	cli.BoolFlag{
+		Name:  "add-to-agent",
+		Usage: "Add the generated private key to ssh-agent until the key expires",
+	},
+}
+
+// generatedKey is a keypair created for a single request, its private key
+// only ever leaves memory if it is written to an identity file.
+type generatedKey struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ssh.PublicKey
+	Comment    string
+}
+
+// generateKey creates a new ed25519 keypair
+func generateKey(comment string) (*generatedKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generatedKey{
+		PrivateKey: priv,
+		PublicKey:  sshPub,
+		Comment:    comment,
+	}, nil
+}
+
+// AuthorizedKey returns the public key in the authorized_keys format
+func (k *generatedKey) AuthorizedKey() string {
+	return fmt.Sprintf("%s %s", strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k.PublicKey))), k.Comment)
+}
+
+// WriteIdentityFile writes the private key to the file in OpenSSH's format,
+// along with its public key and certificate (if the server issued one) in the
+// files next to it where ssh expects to find them. The private key file must
+// not already exist.
+func (k *generatedKey) WriteIdentityFile(file, certificate string) error {
+	block, err := ssh.MarshalPrivateKey(k.PrivateKey, k.Comment)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := writePublicFile(file+".pub", k.AuthorizedKey()); err != nil {
+		return err
+	}
+
+	if certificate != "" {
+		return writePublicFile(file+"-cert.pub", certificate)
+	}
+
+	return nil
+}
+
+func writePublicFile(file, line string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// AddToAgent adds the private key (and its certificate, if the server issued
+// one) to ssh-agent, which will remove it again when it expires.
+func (k *generatedKey) AddToAgent(a sshagent.Agent, certificate string, expires time.Time) error {
+	lifetime := expires.Sub(time.Now())
+	if lifetime < time.Second {
+		return fmt.Errorf("the key has already expired")
+	}
+
+	key := sshagent.AddedKey{
+		PrivateKey:   k.PrivateKey,
+		Comment:      k.Comment,
+		LifetimeSecs: uint32(lifetime.Seconds()),
+	}
+
+	if err := a.Add(key); err != nil {
+		return err
+	}
+
+	if certificate == "" {
+		return nil
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return err
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("the server's certificate is not valid")
+	}
+
+	key.Certificate = cert
+	return a.Add(key)
+}
+
+// sshCommand describes how to connect to a host using the generated key
+func sshCommand(c *cli.Context, user string) string {
+	host := "<host>"
+	if hosts := c.StringSlice("scope-host"); len(hosts) > 0 {
+		host = hosts[0]
+	}
+
+	args := []string{"ssh"}
+	if c.String("identity-file") != "" {
+		args = append(args, "-i", c.String("identity-file"))
+
+		if c.String("certificate") != "" && c.String("certificate") != c.String("identity-file")+"-cert.pub" {
+			args = append(args, "-o", fmt.Sprintf("CertificateFile=%s", c.String("certificate")))
+		}
+	}
+
+	return strings.Join(append(args, fmt.Sprintf("%s@%s", user, host)), " ")
+}
+
+// checkGenerateFlags ensures that the generated private key will end up
+// somewhere it can be used.
+func checkGenerateFlags(c *cli.Context) error {
+	if c.IsSet("file") {
+		return fmt.Errorf("A key file cannot be provided when generating a key")
+	}
+
+	if c.String("identity-file") == "" && !c.Bool("add-to-agent") {
+		return fmt.Errorf("Generated keys must be written to an --identity-file or added to ssh-agent with --add-to-agent")
+	}
+
+	if file := c.String("identity-file"); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("The identity file '%s' already exists", file)
+		} else if !os.IsNotExist(err) {
+			log.
+				WithError(err).
+				WithField("file", file).
+				Debug("Failed to check whether the identity file exists")
+			return fmt.Errorf("Failed to check the identity file '%s'", file)
+		}
+	}
+
+	return nil
+}
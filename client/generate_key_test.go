@@ -0,0 +1,169 @@
+package client
+
+import (
+	"crypto/rand"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestIdentityDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "inki-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newTestCertificate signs a user certificate for the key with a new CA
+func newTestCertificate(t *testing.T, key ssh.PublicKey) string {
+	ca, err := ssh.NewSignerFromKey(newTestEd25519Key(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{
+		Key:         key,
+		CertType:    ssh.UserCert,
+		KeyId:       "test",
+		ValidBefore: ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+}
+
+func assertFileMode(t *testing.T, file string, mode os.FileMode) {
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != mode {
+		t.Errorf("expected %s to have permissions %s but got %s", file, mode, info.Mode().Perm())
+	}
+}
+
+func TestWriteIdentityFile(t *testing.T) {
+	dir, cleanup := newTestIdentityDir(t)
+	defer cleanup()
+
+	key, err := generateKey("alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "id_ed25519")
+	cert := newTestCertificate(t, key.PublicKey)
+	if err := key.WriteIdentityFile(file, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFileMode(t, file, 0600)
+	assertFileMode(t, file+".pub", 0644)
+	assertFileMode(t, file+"-cert.pub", 0644)
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		t.Fatalf("expected the identity file to hold a valid private key but got: %s", err)
+	}
+
+	if ssh.FingerprintSHA256(signer.PublicKey()) != ssh.FingerprintSHA256(key.PublicKey) {
+		t.Error("expected the identity file to hold the generated private key")
+	}
+
+	pub, err := ioutil.ReadFile(file + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(pub) != key.AuthorizedKey()+"\n" {
+		t.Errorf("expected the public key file to hold %q but got %q", key.AuthorizedKey(), pub)
+	}
+
+	certData, err := ioutil.ReadFile(file + "-cert.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(certData) != cert+"\n" {
+		t.Errorf("expected the certificate file to hold %q but got %q", cert, certData)
+	}
+}
+
+func TestWriteIdentityFileWithoutCertificate(t *testing.T) {
+	dir, cleanup := newTestIdentityDir(t)
+	defer cleanup()
+
+	key, err := generateKey("alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "id_ed25519")
+	if err := key.WriteIdentityFile(file, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(file + "-cert.pub"); !os.IsNotExist(err) {
+		t.Error("expected no certificate file to be written")
+	}
+}
+
+func TestWriteIdentityFileRefusesToOverwrite(t *testing.T) {
+	dir, cleanup := newTestIdentityDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "id_ed25519")
+	if err := ioutil.WriteFile(file, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := generateKey("alice@laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := key.WriteIdentityFile(file, ""); err == nil {
+		t.Fatal("expected an existing identity file not to be overwritten")
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "existing" {
+		t.Errorf("expected the existing identity file to be left alone but it holds %q", data)
+	}
+
+	if _, err := os.Stat(file + ".pub"); !os.IsNotExist(err) {
+		t.Error("expected no public key file to be written")
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range generateFlags {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--generate", "--identity-file", file}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkGenerateFlags(cli.NewContext(nil, set, nil)); err == nil {
+		t.Error("expected generating a key for an existing identity file to be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/openpgp"
+)
+
+var pgpKeyIDFlag = cli.StringFlag{
+	Name:   "pgp-key-id, u",
+	Usage:  "The ID, fingerprint or user ID of the PGP key to sign with, chosen from the pgp-key file or from GnuPG's keyring if no pgp-key is provided",
+	EnvVar: "INKI_PGP_KEY_ID",
+}
+
+var gpgProgramFlag = cli.StringFlag{
+	Name:   "gpg-program",
+	Usage:  "The GnuPG executable used to sign requests",
+	Value:  "gpg",
+	EnvVar: "INKI_GPG",
+}
+
+// gpgSigner clearsigns requests by running GnuPG, so that the private key is
+// used through the user's own keyring and gpg-agent rather than being read
+// by Inki.
+type gpgSigner struct {
+	program string
+	keyID   string
+}
+
+func (s *gpgSigner) Sign(body []byte) ([]byte, error) {
+	out := bytes.NewBuffer([]byte{})
+	cmd := exec.Command(s.program, "--clearsign", "--local-user", s.keyID)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = out
+	// GnuPG reports problems (and asks for passphrases through pinentry) on
+	// stderr, which the user needs to see.
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// loadGPGSigner prepares to sign requests with GnuPG, using the key chosen by
+// the pgp-key-id flag.
+func loadGPGSigner(c *cli.Context) (*gpgSigner, error) {
+	program, err := exec.LookPath(c.String("gpg-program"))
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("program", c.String("gpg-program")).
+			Debug("Failed to find GnuPG")
+		return nil, fmt.Errorf("GnuPG could not be found, please install it or provide your key with --pgp-key")
+	}
+
+	return &gpgSigner{
+		program: program,
+		keyID:   c.String("pgp-key-id"),
+	}, nil
+}
+
+// selectPGPKey chooses the entity whose private key should be used to sign
+// requests. The id may be a key ID or fingerprint, in hex, or part of one of
+// the key's user IDs. If no id is provided then the keyring must contain only
+// one private key.
+func selectPGPKey(kr openpgp.EntityList, id string) (*openpgp.Entity, error) {
+	matches := []*openpgp.Entity{}
+	for _, e := range kr {
+		if e.PrivateKey == nil {
+			continue
+		}
+
+		if id == "" || matchesPGPIdentity(e, id) {
+			matches = append(matches, e)
+		}
+	}
+
+	switch {
+	case len(matches) == 0 && id == "":
+		return nil, fmt.Errorf("The pgp-key you provided does not contain a private key")
+	case len(matches) == 0:
+		return nil, fmt.Errorf("The pgp-key you provided does not contain a private key matching '%s'", id)
+	case len(matches) > 1 && id == "":
+		return nil, fmt.Errorf("The pgp-key you provided contains %d private keys, please choose one with --pgp-key-id:\n%s", len(matches), describePGPKeys(matches))
+	case len(matches) > 1:
+		return nil, fmt.Errorf("The pgp-key you provided contains %d private keys matching '%s', please choose one by its key ID:\n%s", len(matches), id, describePGPKeys(matches))
+	}
+
+	return matches[0], nil
+}
+
+func matchesPGPIdentity(e *openpgp.Entity, id string) bool {
+	hex := strings.ToUpper(strings.TrimPrefix(strings.Replace(id, " ", "", -1), "0x"))
+	switch hex {
+	case e.PrimaryKey.KeyIdString(), e.PrimaryKey.KeyIdShortString(), fmt.Sprintf("%X", e.PrimaryKey.Fingerprint):
+		return true
+	}
+
+	for name := range e.Identities {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(id)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func describePGPKeys(keys []*openpgp.Entity) string {
+	lines := []string{}
+	for _, e := range keys {
+		names := []string{}
+		for name := range e.Identities {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		lines = append(lines, fmt.Sprintf(" - %s %s", e.PrimaryKey.KeyIdString(), strings.Join(names, ", ")))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/openpgp"
+)
+
+func newTestEntity(t *testing.T, name, email string) *openpgp.Entity {
+	e, err := openpgp.NewEntity(name, "", email, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+// publicOnly returns a copy of the entity without its private key, as it
+// would be read from a public keyring.
+func publicOnly(e *openpgp.Entity) *openpgp.Entity {
+	pub := *e
+	pub.PrivateKey = nil
+	return &pub
+}
+
+func TestMatchesPGPIdentity(t *testing.T) {
+	e := newTestEntity(t, "Jane Doe", "jane@example.com")
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+
+	for _, id := range []string{
+		e.PrimaryKey.KeyIdString(),
+		strings.ToLower(e.PrimaryKey.KeyIdShortString()),
+		"0x" + e.PrimaryKey.KeyIdString(),
+		fingerprint,
+		fingerprint[:20] + " " + fingerprint[20:],
+		"jane@example.com",
+		"JANE DOE",
+	} {
+		if !matchesPGPIdentity(e, id) {
+			t.Errorf("expected the key to match '%s'", id)
+		}
+	}
+
+	for _, id := range []string{"john@example.com", "0123456789ABCDEF"} {
+		if matchesPGPIdentity(e, id) {
+			t.Errorf("expected the key not to match '%s'", id)
+		}
+	}
+}
+
+func TestSelectPGPKey(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@example.com")
+	john := newTestEntity(t, "John Doe", "john@example.com")
+	bob := newTestEntity(t, "Bob Smith", "bob@example.com")
+	kr := openpgp.EntityList{jane, john, publicOnly(bob)}
+
+	e, err := selectPGPKey(kr, "jane@")
+	if err != nil || e != jane {
+		t.Errorf("expected Jane's key to be chosen by her user ID but got %v, %v", e, err)
+	}
+
+	e, err = selectPGPKey(kr, john.PrimaryKey.KeyIdString())
+	if err != nil || e != john {
+		t.Errorf("expected John's key to be chosen by its key ID but got %v, %v", e, err)
+	}
+
+	if _, err := selectPGPKey(kr, "bob@example.com"); err == nil {
+		t.Error("expected a key without its private key not to be chosen")
+	}
+
+	if _, err := selectPGPKey(kr, "alice@example.com"); err == nil {
+		t.Error("expected an error when no key matches")
+	}
+
+	_, err = selectPGPKey(kr, "")
+	if err == nil || !strings.Contains(err.Error(), jane.PrimaryKey.KeyIdString()) || !strings.Contains(err.Error(), john.PrimaryKey.KeyIdString()) {
+		t.Errorf("expected the ambiguous private keys to be listed but got %v", err)
+	}
+
+	_, err = selectPGPKey(kr, "doe")
+	if err == nil || !strings.Contains(err.Error(), "matching 'doe'") {
+		t.Errorf("expected the keys matching the ID to be ambiguous but got %v", err)
+	}
+
+	e, err = selectPGPKey(openpgp.EntityList{jane, publicOnly(bob)}, "")
+	if err != nil || e != jane {
+		t.Errorf("expected the only private key to be chosen but got %v, %v", e, err)
+	}
+
+	if _, err := selectPGPKey(openpgp.EntityList{publicOnly(bob)}, ""); err == nil {
+		t.Error("expected an error when the keyring has no private keys")
+	}
+}
+
+func TestDescribePGPKeys(t *testing.T) {
+	jane := newTestEntity(t, "Jane Doe", "jane@example.com")
+	john := newTestEntity(t, "John Doe", "john@example.com")
+
+	expected := fmt.Sprintf(" - %s Jane Doe <jane@example.com>\n - %s John Doe <john@example.com>", jane.PrimaryKey.KeyIdString(), john.PrimaryKey.KeyIdString())
+	if desc := describePGPKeys([]*openpgp.Entity{jane, john}); desc != expected {
+		t.Errorf("expected the keys to be described as\n%s\nbut got\n%s", expected, desc)
+	}
+}
+
+// newTestGPGProgram writes a script which stands in for GnuPG, recording its
+// arguments and wrapping its input in a fake signature.
+func newTestGPGProgram(t *testing.T, dir, script string) string {
+	program := filepath.Join(dir, "gpg")
+	if err := ioutil.WriteFile(program, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return program
+}
+
+func TestGPGSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inki-gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	args := filepath.Join(dir, "args")
+	program := newTestGPGProgram(t, dir, fmt.Sprintf(`echo "$@" > '%s'
+echo "-----BEGIN PGP SIGNED MESSAGE-----"
+cat
+echo
+echo "-----BEGIN PGP SIGNATURE-----"
+`, args))
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	pgpKeyIDFlag.Apply(set)
+	gpgProgramFlag.Apply(set)
+	if err := set.Parse([]string{"--gpg-program", program, "--pgp-key-id", "jane@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := loadGPGSigner(cli.NewContext(nil, set, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := signer.Sign([]byte(`{"user":"jane"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(signed), "-----BEGIN PGP SIGNED MESSAGE-----\n{\"user\":\"jane\"}\n") {
+		t.Errorf("expected the request to be signed by GnuPG but got %q", signed)
+	}
+
+	used, err := ioutil.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(used)) != "--clearsign --local-user jane@example.com" {
+		t.Errorf("expected GnuPG to clearsign with the chosen key but it was run with '%s'", strings.TrimSpace(string(used)))
+	}
+
+	signer.program = newTestGPGProgram(t, dir, "exit 2\n")
+	if _, err := signer.Sign([]byte(`{"user":"jane"}`)); err == nil {
+		t.Error("expected an error when GnuPG fails to sign the request")
+	}
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var hostConfigFlag = cli.StringFlag{
+	Name:   "config, c",
+	Usage:  "The configuration file which holds the defaults for this host",
+	Value:  "/etc/inki/inki.yml",
+	EnvVar: "INKI_CONFIG",
+}
+
+// hostConfig holds the defaults for the commands which run on a host. These
+// are needed because sshd runs its AuthorizedKeysCommand without any of the
+// environment variables you may have configured.
+type hostConfig struct {
+	Server     string   `yaml:"server"`
+	Host       string   `yaml:"host"`
+	Groups     []string `yaml:"groups"`
+	Token      string   `yaml:"token"`
+	CACert     string   `yaml:"ca_cert"`
+	ClientCert string   `yaml:"client_cert"`
+	ClientKey  string   `yaml:"client_key"`
+	Cache      string   `yaml:"cache"`
+	Timeout    string   `yaml:"timeout"`
+}
+
+// loadHostConfig reads the host's configuration file, if it exists, and uses
+// it for any flags which were not provided on the command line or through
+// environment variables.
+func loadHostConfig(c *cli.Context) error {
+	file := c.String("config")
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) && !c.IsSet("config") {
+		return nil
+	}
+
+	if err != nil {
+		log.WithError(err).WithField("file", file).Debug("Failed to read configuration file")
+		return fmt.Errorf("Failed to read the configuration file '%s'", file)
+	}
+
+	var cfg hostConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		log.WithError(err).WithField("file", file).Debug("Failed to parse configuration file")
+		return fmt.Errorf("Failed to parse the configuration file '%s'", file)
+	}
+
+	defaults := map[string]string{
+		"server":      cfg.Server,
+		"host":        cfg.Host,
+		"token":       cfg.Token,
+		"ca-cert":     cfg.CACert,
+		"client-cert": cfg.ClientCert,
+		"client-key":  cfg.ClientKey,
+		"cache":       cfg.Cache,
+		"timeout":     cfg.Timeout,
+	}
+
+	for name, value := range defaults {
+		if value == "" || c.IsSet(name) || !hasFlag(c, name) {
+			continue
+		}
+
+		if err := c.Set(name, value); err != nil {
+			return fmt.Errorf("The %s in the configuration file '%s' is not valid", name, file)
+		}
+	}
+
+	if !c.IsSet("group") && hasFlag(c, "group") {
+		for _, group := range cfg.Groups {
+			if err := c.Set("group", group); err != nil {
+				return fmt.Errorf("The groups in the configuration file '%s' are not valid", file)
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasFlag(c *cli.Context, name string) bool {
+	for _, n := range c.FlagNames() {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
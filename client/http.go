@@ -0,0 +1,114 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var transportFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "token",
+		Usage:  "The bearer token used to authenticate with the server when reading keys",
+		EnvVar: "INKI_TOKEN",
+	},
+	cli.StringFlag{
+		Name:   "ca-cert",
+		Usage:  "The CA bundle used to verify the server's TLS certificate",
+		EnvVar: "INKI_CA_CERT",
+	},
+	cli.StringFlag{
+		Name:   "client-cert",
+		Usage:  "The TLS client certificate used to authenticate with the server",
+		EnvVar: "INKI_CLIENT_CERT",
+	},
+	cli.StringFlag{
+		Name:   "client-key",
+		Usage:  "The private key for the TLS client certificate",
+		EnvVar: "INKI_CLIENT_KEY",
+	},
+}
+
+// httpClient builds an HTTP client which uses the token, CA bundle and client
+// certificate specified by the transport flags.
+func httpClient(c *cli.Context) (*http.Client, error) {
+	if c.String("ca-cert") == "" && c.String("client-cert") == "" && c.String("token") == "" {
+		return http.DefaultClient, nil
+	}
+
+	cfg := &tls.Config{}
+
+	if c.String("ca-cert") != "" {
+		data, err := ioutil.ReadFile(c.String("ca-cert"))
+		if err != nil {
+			log.
+				WithError(err).
+				WithField("file", c.String("ca-cert")).
+				Debug("Failed to read CA bundle")
+			return nil, fmt.Errorf("Failed to read the CA bundle you provided")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("No certificates could be read from the CA bundle you provided")
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if c.String("client-cert") != "" {
+		cert, err := tls.LoadX509KeyPair(c.String("client-cert"), c.String("client-key"))
+		if err != nil {
+			log.
+				WithError(err).
+				WithFields(log.Fields{
+					"cert": c.String("client-cert"),
+					"key":  c.String("client-key"),
+				}).
+				Debug("Failed to load client certificate")
+			return nil, fmt.Errorf("Failed to load the client certificate you provided")
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: cfg,
+	}
+
+	if c.String("token") != "" {
+		transport = &tokenTransport{
+			token: c.String("token"),
+			next:  transport,
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+// tokenTransport adds a bearer token to every request made through it
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// RoundTrippers should not modify the request they are given
+	req := new(http.Request)
+	*req = *r
+	req.Header = http.Header{}
+	for k, v := range r.Header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.token))
+	return t.next.RoundTrip(req)
+}
@@ -9,5 +9,8 @@ var KeysCommands = cli.Command{
 	Subcommands: []cli.Command{
 		addKeyCommand,
 		listKeysCommand,
+		removeKeyCommand,
+		pendingKeysCommand,
+		approveKeyCommand,
 	},
 }
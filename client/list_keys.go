@@ -3,8 +3,10 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"net/url"
+	neturl "net/url"
+	"strings"
 
 	"os"
 
@@ -16,7 +18,7 @@ import (
 var listKeysCommand = cli.Command{
 	Name:  "list",
 	Usage: "Gets the list of keys currently registered on the server",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "authorized-keys, a",
 			Usage: "Format the resulting data in a way that is compatible with authorized_keys",
@@ -25,39 +27,42 @@ var listKeysCommand = cli.Command{
 			Name:  "expired, x",
 			Usage: "Includes keys which have expired in the output",
 		},
-	},
+		cli.StringFlag{
+			Name:   "host, H",
+			Usage:  "The name of the host which keys are being listed for, so that only keys scoped to it are included",
+			EnvVar: "INKI_HOST",
+		},
+	}, transportFlags...),
 	Before: func(c *cli.Context) error {
 		log.SetOutput(os.Stderr)
 		return nil
 	},
 	Action: func(c *cli.Context) error {
-		if c.NArg() < 1 {
-			return fmt.Errorf("Missing user and host argument")
-		}
-
-		u, err := url.Parse(c.Args().First())
+		u, err := parseTarget(c)
 		if err != nil {
-			log.WithError(err).Error("Failed to parse host URL")
-			return fmt.Errorf("Failed to parse user and host argument")
+			return err
 		}
 
-		if u.User == nil || u.User.String() == "" {
-			log.Error("Host URL did not contain a username")
-			return fmt.Errorf("Host address did not contain a username")
-		}
+		allowExpired := c.IsSet("expired")
 
-		if u.Scheme == "" {
-			u.Scheme = "http"
-		}
+		// When producing authorized_keys output, the server is responsible for
+		// deciding which keys apply to this host and how they should be rendered.
+		serverRendered := c.IsSet("authorized-keys") && !allowExpired
 
 		server := fmt.Sprintf("%s://%s", u.Scheme, u.Host)
 		url := server
-		if u.User.Username() != "" {
+		if serverRendered {
+			url = fmt.Sprintf("%s/api/v1/user/%s/authorized_keys", url, u.User.Username())
+		} else if u.User.Username() != "" {
 			url = fmt.Sprintf("%s/api/v1/user/%s/keys", url, u.User.Username())
 		} else {
 			url = fmt.Sprintf("%s/api/v1/keys", url)
 		}
 
+		if c.String("host") != "" {
+			url = fmt.Sprintf("%s?host=%s", url, neturl.QueryEscape(c.String("host")))
+		}
+
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			log.WithError(err).Error("Failed to prepare request for keys")
@@ -69,7 +74,12 @@ var listKeysCommand = cli.Command{
 			"user":   u.User.Username(),
 		}).Info("Fetching authorized keys")
 
-		res, err := http.DefaultClient.Do(req)
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
 		if err != nil {
 			log.WithError(err).Error("Failed to make request for user keys")
 			return fmt.Errorf("Request for user keys failed to server '%s'", url)
@@ -81,7 +91,16 @@ var listKeysCommand = cli.Command{
 				"server": server,
 				"status": res.StatusCode,
 			}).Error("Failed to get list of keys")
-			return fmt.Errorf("Failed to get list of keys")
+			return responseError("Failed to get list of keys", res)
+		}
+
+		if serverRendered {
+			if _, err := io.Copy(os.Stdout, res.Body); err != nil {
+				log.WithError(err).Error("Failed to read response from server")
+				return fmt.Errorf("Failed to read response from server")
+			}
+
+			return nil
 		}
 
 		keys := []crypto.Key{}
@@ -90,12 +109,11 @@ var listKeysCommand = cli.Command{
 			return fmt.Errorf("Failed to parse response from server")
 		}
 
-		allowExpired := c.IsSet("expired")
 		if c.IsSet("authorized-keys") {
 			for _, k := range keys {
 				err := k.Validate()
 				if allowExpired || err == nil {
-					fmt.Printf("%s\n", k.PublicKey)
+					fmt.Printf("%s\n", k.AuthorizedKey())
 				}
 			}
 		} else {
@@ -106,6 +124,12 @@ var listKeysCommand = cli.Command{
 					fmt.Printf(" - Username:     %s\n", k.User)
 					fmt.Printf("   Fingerprint:  %s\n", k.Fingerprint())
 					fmt.Printf("   Expires:      %s\n", k.Expires)
+					if len(k.Options) > 0 {
+						fmt.Printf("   Options:      %s\n", strings.Join(k.Options, ","))
+					}
+					if k.Scoped() {
+						fmt.Printf("   Scope:        %s\n", strings.Join(append(k.Hosts, k.Groups...), ", "))
+					}
 					fmt.Println()
 				}
 			}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var removeKeyCommand = cli.Command{
+	Name:      "remove",
+	Usage:     "Revokes an SSH key which has been registered with the Inki key server",
+	UsageText: "user@inki-server fingerprint",
+	Flags: append(append([]cli.Flag{
+		audienceFlag,
+	}, signingFlags...), transportFlags...),
+	Before: func(c *cli.Context) error {
+		log.SetOutput(os.Stderr)
+		return nil
+	},
+	Action: func(c *cli.Context) error {
+		u, err := parseTarget(c)
+		if err != nil {
+			return err
+		}
+
+		if c.NArg() < 2 {
+			return fmt.Errorf("Missing key fingerprint argument")
+		}
+
+		revocation := &crypto.ShortKey{
+			User:        u.User.Username(),
+			Fingerprint: c.Args().Get(1),
+		}
+
+		signer, err := loadSigner(c, true)
+		if err != nil {
+			return err
+		}
+
+		reqData, err := signRequest(signer, revocation, requestAudience(c, u))
+		if err != nil {
+			return err
+		}
+
+		url := fmt.Sprintf("%s://%s/api/v1/user/%s/key/%s", u.Scheme, u.Host, revocation.User, neturl.PathEscape(revocation.Fingerprint))
+		req, err := http.NewRequest("DELETE", url, reqData)
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to prepare request")
+			return fmt.Errorf("Failed to prepare request to server")
+		}
+
+		log.WithFields(log.Fields{
+			"user":        revocation.User,
+			"fingerprint": revocation.Fingerprint,
+		}).Info("Revoking key for user")
+
+		client, err := httpClient(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			log.
+				WithError(err).
+				WithFields(log.Fields{
+					"server": u.Host,
+				}).
+				Debug("Failed to send revocation request to server")
+			return fmt.Errorf("Failed to send revocation request to server '%s'", u.Host)
+		}
+
+		if res.StatusCode != 200 {
+			log.
+				WithFields(log.Fields{
+					"server": u.Host,
+					"status": res.StatusCode,
+				}).
+				Debug("Failed to send revocation request to server")
+			return responseError("Failed to send revocation request to server", res)
+		}
+
+		keys := []crypto.Key{}
+		if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to parse response from server")
+			return fmt.Errorf("Failed to parse response from server")
+		}
+
+		fmt.Println("Removed keys:")
+		for _, k := range keys {
+			fmt.Printf(" - Username:     %s\n", k.User)
+			fmt.Printf("   Fingerprint:  %s\n", k.Fingerprint())
+			fmt.Printf("   Expires:      %s\n", k.Expires)
+			fmt.Println()
+		}
+
+		return nil
+	},
+}
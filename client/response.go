@@ -0,0 +1,24 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type apiError struct {
+	Code    int    `json:"code"`
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// responseError builds an error describing why the server rejected a request,
+// using the message provided by the server where one is available.
+func responseError(action string, res *http.Response) error {
+	var e apiError
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil || e.Message == "" {
+		return fmt.Errorf("%s: %s", action, res.Status)
+	}
+
+	return fmt.Errorf("%s: %s", action, e.Message)
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var pgpKeyFlag = cli.StringFlag{
+	Name:  "pgp-key, p",
+	Usage: "The PGP private key you wish to use to sign this request",
+}
+
+var sshKeyFlag = cli.StringFlag{
+	Name:  "ssh-key",
+	Usage: "The SSH private key you wish to use to sign this request, or its public key to sign using ssh-agent",
+}
+
+// signingFlags choose the key which is used to sign requests
+var signingFlags = []cli.Flag{
+	pgpKeyFlag,
+	pgpKeyIDFlag,
+	gpgProgramFlag,
+	sshKeyFlag,
+	sshAgentFlag,
+	sshIdentityFlag,
+}
+
+var audienceFlag = cli.StringFlag{
+	Name:  "audience",
+	Usage: "The audience this request is intended for, defaults to the server's address",
+}
+
+// requestAudience determines the audience which a signed request should be
+// bound to when it is sent to the server at u.
+func requestAudience(c *cli.Context, u *url.URL) string {
+	if c.IsSet("audience") {
+		return c.String("audience")
+	}
+
+	return fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+}
+
+// requestSigner signs the encoded body of a request
+type requestSigner interface {
+	Sign(body []byte) ([]byte, error)
+}
+
+// pgpSigner clearsigns requests with a PGP private key
+type pgpSigner struct {
+	key *packet.PrivateKey
+}
+
+func (s *pgpSigner) Sign(body []byte) ([]byte, error) {
+	reqData := bytes.NewBuffer([]byte{})
+	reqStream, err := clearsign.Encode(reqData, s.key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := reqStream.Write(body); err != nil {
+		return nil, err
+	}
+
+	if err := reqStream.Close(); err != nil {
+		return nil, err
+	}
+
+	return reqData.Bytes(), nil
+}
+
+// sshSigner follows requests with an SSHSIG signature made by an SSH key
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func (s *sshSigner) Sign(body []byte) ([]byte, error) {
+	sig, err := crypto.SignSSH(s.signer, body, crypto.SSHSignatureNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, body...), sig...), nil
+}
+
+// loadSigner loads the key which should be used to sign requests, using
+// ssh-agent or the ssh-key flag if they have been provided and a PGP key,
+// from the pgp-key file or GnuPG, otherwise.
+func loadSigner(c *cli.Context, canPrompt bool) (requestSigner, error) {
+	if c.Bool("ssh-agent") || c.String("ssh-identity") != "" {
+		signer, err := loadAgentSigner(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sshSigner{signer}, nil
+	}
+
+	if c.String("ssh-key") != "" {
+		signer, err := loadSSHSigningKey(c, canPrompt)
+		if err != nil {
+			return nil, err
+		}
+
+		return &sshSigner{signer}, nil
+	}
+
+	// Without a key file, keys are chosen from the user's GnuPG keyring
+	if c.String("pgp-key") == "" && c.String("pgp-key-id") != "" {
+		return loadGPGSigner(c)
+	}
+
+	pk, err := loadSigningKey(c, canPrompt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pgpSigner{pk}, nil
+}
+
+// loadSSHSigningKey reads the SSH private key specified by the ssh-key flag,
+// prompting the user for its passphrase if it is encrypted and canPrompt is
+// set. If the file holds a public key then ssh-agent is used to sign with it.
+func loadSSHSigningKey(c *cli.Context, canPrompt bool) (ssh.Signer, error) {
+	file := c.String("ssh-key")
+	p, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("file", file).
+			Debug("Failed to read the ssh-key file")
+		return nil, fmt.Errorf("Failed to read the ssh-key you provided")
+	}
+
+	if pub, _, _, _, err := ssh.ParseAuthorizedKey(p); err == nil {
+		a, err := dialAgent()
+		if err != nil {
+			return nil, err
+		}
+
+		return agentSignerFor(a, pub)
+	}
+
+	signer, err := ssh.ParsePrivateKey(p)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		if !canPrompt {
+			log.
+				Debug("Private key is encrypted and stdin has been used to read the SSH key")
+			return nil, fmt.Errorf("Private key is encrypted and stdin was used to read the SSH key")
+		}
+
+		fmt.Print("Enter SSH key passphrase: ")
+		pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to request passphrase from user")
+			return nil, fmt.Errorf("Failed to request passphrase input")
+		}
+
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(p, pw)
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to decrypt the SSH private key")
+			return nil, fmt.Errorf("Failed to decrypt the SSH private key, please check that your passphrase is correct")
+		}
+
+		return signer, nil
+	}
+
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("file", file).
+			Debug("Failed to decode the ssh-key file")
+		return nil, fmt.Errorf("Failed to decode the ssh-key you provided")
+	}
+
+	return signer, nil
+}
+
+// loadSigningKey reads the PGP private key specified by the pgp-key flag,
+// choosing between keys with the pgp-key-id flag and prompting the user
+// for its password if it is encrypted and canPrompt is set.
+func loadSigningKey(c *cli.Context, canPrompt bool) (*packet.PrivateKey, error) {
+	p, err := ioutil.ReadFile(c.String("pgp-key"))
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("file", c.String("pgp-key")).
+			Debug("Failed to read the pgp-key file")
+		return nil, fmt.Errorf("Failed to read the pgp-key you provided")
+	}
+
+	kr, err := openpgp.ReadArmoredKeyRing(bytes.NewBuffer(p))
+	if err != nil {
+		log.WithError(err).
+			WithField("file", c.String("pgp-key")).
+			Debug("Failed to decode the pgp-key file")
+		return nil, fmt.Errorf("Failed to decode the pgp-key you provided")
+	}
+
+	e, err := selectPGPKey(kr, c.String("pgp-key-id"))
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("file", c.String("pgp-key")).
+			Debug("Failed to find a private key in the pgp-key file")
+		return nil, err
+	}
+
+	pk := e.PrivateKey
+
+	if pk.Encrypted {
+		if !canPrompt {
+			log.
+				Debug("Private key is encrypted and stdin has been used to read the SSH key")
+			return nil, fmt.Errorf("Private key is encrypted and stdin was used to read the SSH key")
+		}
+
+		fmt.Print("Enter PGP key password: ")
+		pw, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to request password from user")
+			return nil, fmt.Errorf("Failed to request password input")
+		}
+
+		err = pk.Decrypt(pw)
+		if err != nil {
+			log.
+				WithError(err).
+				Debug("Failed to decrypt the PGP private key")
+			return nil, fmt.Errorf("Failed to decrypt the PGP private key, please check that your password is correct")
+		}
+	}
+
+	return pk, nil
+}
+
+// signRequest encodes the provided payload as JSON, along with a fresh set
+// of claims for the audience, and signs it using the provided signer.
+func signRequest(signer requestSigner, payload interface{}, audience string) (*bytes.Buffer, error) {
+	claims, err := crypto.NewClaims(audience)
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to generate request claims")
+		return nil, fmt.Errorf("Failed to generate request claims")
+	}
+
+	body, err := withClaims(payload, claims)
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to encode request")
+		return nil, fmt.Errorf("Failed to encode request")
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to encode request")
+		return nil, fmt.Errorf("Failed to encode request")
+	}
+
+	reqData, err := signer.Sign(append(data, '\n'))
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to sign request")
+		return nil, fmt.Errorf("Failed to sign request")
+	}
+
+	return bytes.NewBuffer(reqData), nil
+}
+
+// withClaims merges the fields of the claims into those of the payload so
+// that they are signed as a single JSON object.
+func withClaims(payload interface{}, claims *crypto.Claims) (map[string]json.RawMessage, error) {
+	body := map[string]json.RawMessage{}
+	for _, part := range []interface{}{payload, claims} {
+		data, err := json.Marshal(part)
+		if err != nil {
+			return nil, err
+		}
+
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+
+		for k, v := range fields {
+			body[k] = v
+		}
+	}
+
+	return body, nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+var sshAgentFlag = cli.BoolFlag{
+	Name:  "ssh-agent, A",
+	Usage: "Sign this request using a key held by ssh-agent (through SSH_AUTH_SOCK)",
+}
+
+var sshIdentityFlag = cli.StringFlag{
+	Name:  "ssh-identity, i",
+	Usage: "The fingerprint or comment of the ssh-agent key to sign with, required if ssh-agent holds more than one key",
+}
+
+// dialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK
+func dialAgent() (sshagent.ExtendedAgent, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set, please start ssh-agent")
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		log.
+			WithError(err).
+			WithField("socket", sock).
+			Debug("Failed to connect to ssh-agent")
+		return nil, fmt.Errorf("Failed to connect to ssh-agent")
+	}
+
+	return sshagent.NewClient(conn), nil
+}
+
+// loadAgentSigner selects the key held by ssh-agent which should be used to
+// sign requests, using the ssh-identity flag to choose between keys.
+func loadAgentSigner(c *cli.Context) (ssh.Signer, error) {
+	a, err := dialAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	return selectAgentSigner(a, c.String("ssh-identity"))
+}
+
+// selectAgentSigner finds the agent's key whose fingerprint (SHA256 or MD5)
+// or comment matches the identity. If no identity is provided then the agent
+// must hold exactly one key.
+func selectAgentSigner(a sshagent.Agent, identity string) (ssh.Signer, error) {
+	keys, err := a.List()
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to list the keys held by ssh-agent")
+		return nil, fmt.Errorf("Failed to list the keys held by ssh-agent")
+	}
+
+	matches := []*sshagent.Key{}
+	for _, k := range keys {
+		if identity == "" || matchesAgentIdentity(k, identity) {
+			matches = append(matches, k)
+		}
+	}
+
+	switch {
+	case len(keys) == 0:
+		return nil, fmt.Errorf("ssh-agent does not hold any keys, please add one with ssh-add")
+	case len(matches) == 0:
+		return nil, fmt.Errorf("ssh-agent does not hold a key matching '%s'", identity)
+	case len(matches) > 1 && identity == "":
+		return nil, fmt.Errorf("ssh-agent holds %d keys, please choose one with --ssh-identity:\n%s", len(matches), describeAgentKeys(matches))
+	case len(matches) > 1:
+		return nil, fmt.Errorf("ssh-agent holds %d keys matching '%s', please choose one by its fingerprint:\n%s", len(matches), identity, describeAgentKeys(matches))
+	}
+
+	return agentSignerFor(a, matches[0])
+}
+
+func matchesAgentIdentity(k *sshagent.Key, identity string) bool {
+	if k.Comment == identity {
+		return true
+	}
+
+	key := &crypto.Key{PublicKey: string(ssh.MarshalAuthorizedKey(k))}
+	return key.MatchesFingerprint(identity)
+}
+
+func describeAgentKeys(keys []*sshagent.Key) string {
+	lines := []string{}
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf(" - %s %s", ssh.FingerprintSHA256(k), k.Comment))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// agentSignerFor returns the agent's signer for the public key
+func agentSignerFor(a sshagent.Agent, pub ssh.PublicKey) (ssh.Signer, error) {
+	signers, err := a.Signers()
+	if err != nil {
+		log.
+			WithError(err).
+			Debug("Failed to list the keys held by ssh-agent")
+		return nil, fmt.Errorf("Failed to list the keys held by ssh-agent")
+	}
+
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), pub.Marshal()) {
+			return s, nil
+		}
+	}
+
+	return nil, fmt.Errorf("The key %s has not been added to ssh-agent", ssh.FingerprintSHA256(pub))
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	sshagent "golang.org/x/crypto/ssh/agent"
+)
+
+// newTestAgent serves a keyring holding the keys over the agent protocol, so
+// that signing goes through the same client as a real ssh-agent.
+func newTestAgent(t *testing.T, keys map[string]interface{}) (sshagent.ExtendedAgent, func()) {
+	keyring := sshagent.NewKeyring()
+	for comment, key := range keys {
+		if err := keyring.Add(sshagent.AddedKey{PrivateKey: key, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client, server := net.Pipe()
+	go sshagent.ServeAgent(keyring, server)
+
+	return sshagent.NewClient(client), func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func newTestEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return priv
+}
+
+func TestSelectAgentSigner(t *testing.T) {
+	work := newTestEd25519Key(t)
+	personal := newTestEd25519Key(t)
+	a, cleanup := newTestAgent(t, map[string]interface{}{
+		"alice@work":   work,
+		"alice@laptop": personal,
+	})
+	defer cleanup()
+
+	workPub, err := ssh.NewPublicKey(work.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, identity := range []string{"alice@work", ssh.FingerprintSHA256(workPub), ssh.FingerprintLegacyMD5(workPub)} {
+		signer, err := selectAgentSigner(a, identity)
+		if err != nil {
+			t.Errorf("expected a key to be selected by '%s' but got: %s", identity, err)
+			continue
+		}
+
+		if ssh.FingerprintSHA256(signer.PublicKey()) != ssh.FingerprintSHA256(workPub) {
+			t.Errorf("expected '%s' to select the work key", identity)
+		}
+	}
+
+	if _, err := selectAgentSigner(a, ""); err == nil || !strings.Contains(err.Error(), "--ssh-identity") {
+		t.Errorf("expected to be asked to choose between the agent's keys but got: %v", err)
+	}
+
+	if _, err := selectAgentSigner(a, "bob@work"); err == nil {
+		t.Error("expected no key to be selected for an identity the agent doesn't hold")
+	}
+}
+
+func TestSelectAgentSignerEmpty(t *testing.T) {
+	a, cleanup := newTestAgent(t, nil)
+	defer cleanup()
+
+	if _, err := selectAgentSigner(a, ""); err == nil {
+		t.Error("expected an error when the agent holds no keys")
+	}
+}
+
+func TestAgentSignerRSA(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, cleanup := newTestAgent(t, map[string]interface{}{"alice@work": priv})
+	defer cleanup()
+
+	signer, err := selectAgentSigner(a, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"user":"root"}` + "\n")
+	signed, err := (&sshSigner{signer}).Sign(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := crypto.ReadRequests(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 || reqs[0].SSHSignature == nil {
+		t.Fatalf("expected a single SSH signed request but got %v", reqs)
+	}
+
+	sig := reqs[0].SSHSignature
+	if sig.Signature.Format != ssh.KeyAlgoRSASHA512 {
+		t.Errorf("expected the agent to sign with %s but got %s", ssh.KeyAlgoRSASHA512, sig.Signature.Format)
+	}
+
+	if err := sig.Verify(reqs[0].Payload, crypto.SSHSignatureNamespace); err != nil {
+		t.Errorf("expected the signature to be valid but got: %s", err)
+	}
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// parseTarget reads the user@host address provided as the first argument
+// to a command, ensuring that it includes a username and URL scheme.
+func parseTarget(c *cli.Context) (*url.URL, error) {
+	if c.NArg() < 1 {
+		return nil, fmt.Errorf("Missing user and host argument")
+	}
+
+	u, err := url.Parse(c.Args().First())
+	if err != nil {
+		log.WithError(err).Error("Failed to parse host URL")
+		return nil, fmt.Errorf("Failed to parse user and host argument")
+	}
+
+	if u.User == nil || u.User.String() == "" {
+		log.Error("Host URL did not contain a username")
+		return nil, fmt.Errorf("Host address did not contain a username")
+	}
+
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+
+	return u, nil
+}
@@ -0,0 +1,23 @@
+package crypto
+
+import "time"
+
+// PendingKey is a request to add a key which is waiting to be approved
+type PendingKey struct {
+	ID          string    `json:"id"`
+	Key         Key       `json:"key"`
+	Requested   time.Time `json:"requested"`
+	RequestedBy string    `json:"requested_by"`
+	Required    int       `json:"required"`
+	Approvals   []string  `json:"approvals"`
+}
+
+// Approved determines whether the key has received enough approvals
+func (p *PendingKey) Approved() bool {
+	return len(p.Approvals) >= p.Required
+}
+
+// Approval is the payload signed by an approver to approve a pending key
+type Approval struct {
+	Approve string `json:"approve"`
+}
@@ -0,0 +1,31 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Claims are included in every signed request to bind it to the time at
+// which it was issued and the server it was intended for, preventing it
+// from being replayed later or against another server.
+type Claims struct {
+	IssuedAt time.Time `json:"issued_at"`
+	Nonce    string    `json:"nonce"`
+	Audience string    `json:"audience"`
+}
+
+// NewClaims generates a fresh set of claims for a request which will be
+// sent to the given audience.
+func NewClaims(audience string) (*Claims, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &Claims{
+		IssuedAt: time.Now().UTC(),
+		Nonce:    hex.EncodeToString(nonce),
+		Audience: audience,
+	}, nil
+}
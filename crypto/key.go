@@ -3,8 +3,10 @@ package crypto
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -19,6 +21,20 @@ type Key struct {
 	Expires   time.Time `json:"expire"`
 	PublicKey string    `json:"key"`
 	User      string    `json:"user"`
+	Options   []string  `json:"options,omitempty"`
+
+	// Hosts and Groups limit the hosts to which this key will be served,
+	// if neither is set then the key is served to every host.
+	Hosts  []string `json:"hosts,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+
+	// Certificate is the OpenSSH user certificate issued for this key, if
+	// the server is acting as a certificate authority.
+	Certificate string `json:"certificate,omitempty"`
+
+	// PendingID identifies the approval request for this key, if it must be
+	// approved before it becomes active.
+	PendingID string `json:"pending_id,omitempty"`
 }
 
 func (k *Key) Validate() error {
@@ -27,6 +43,12 @@ func (k *Key) Validate() error {
 		return err
 	}
 
+	for _, o := range k.Options {
+		if err := ValidateOption(o); err != nil {
+			return err
+		}
+	}
+
 	if time.Now().After(k.Expires) {
 		return fmt.Errorf("key has expired")
 	}
@@ -34,17 +56,148 @@ func (k *Key) Validate() error {
 	return nil
 }
 
+// AuthorizedKey renders this key, with its options, as a line suitable for
+// use in an authorized_keys file. Any options embedded in the original public
+// key are discarded in favour of the key's Options.
+func (k *Key) AuthorizedKey() string {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil {
+		return ""
+	}
+
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		line = fmt.Sprintf("%s %s", line, comment)
+	}
+
+	if len(k.Options) > 0 {
+		line = fmt.Sprintf("%s %s", strings.Join(k.Options, ","), line)
+	}
+
+	return line
+}
+
+// Fingerprint returns the key's fingerprint in the SHA256:... format used by
+// OpenSSH, or an empty string if the key is invalid.
 func (k *Key) Fingerprint() string {
 	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
 	if err != nil {
 		return ""
 	}
 
+	return ssh.FingerprintSHA256(key)
+}
+
+// FingerprintMD5 returns the key's legacy MD5 fingerprint as a hex string,
+// or an empty string if the key is invalid.
+func (k *Key) FingerprintMD5() string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil {
+		return ""
+	}
+
 	h := md5.New()
 	h.Write(key.Marshal())
 	return fmt.Sprintf("%0x", h.Sum(nil))
 }
 
+// MatchesFingerprint determines whether the fingerprint, in any of the
+// formats accepted by NormalizeFingerprint, identifies this key.
+func (k *Key) MatchesFingerprint(fingerprint string) bool {
+	fingerprint = NormalizeFingerprint(fingerprint)
+	if fingerprint == "" {
+		return false
+	}
+
+	return fingerprint == k.Fingerprint() || fingerprint == k.FingerprintMD5()
+}
+
+// NormalizeFingerprint converts a fingerprint into the format returned by
+// Fingerprint or FingerprintMD5. MD5 fingerprints may be given as hex, with
+// or without colons and the MD5: prefix used by OpenSSH.
+func NormalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if strings.HasPrefix(fingerprint, "SHA256:") {
+		return fingerprint
+	}
+
+	return strings.ToLower(strings.Replace(strings.TrimPrefix(fingerprint, "MD5:"), ":", "", -1))
+}
+
+// Type returns the short name of this key's algorithm (rsa, dsa, ecdsa,
+// ed25519, ecdsa-sk or ed25519-sk), or an empty string if the key is invalid.
+func (k *Key) Type() string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil {
+		return ""
+	}
+
+	switch t := key.Type(); {
+	case t == ssh.KeyAlgoRSA:
+		return "rsa"
+	case t == ssh.KeyAlgoDSA:
+		return "dsa"
+	case t == ssh.KeyAlgoED25519:
+		return "ed25519"
+	case t == ssh.KeyAlgoSKED25519:
+		return "ed25519-sk"
+	case t == ssh.KeyAlgoSKECDSA256:
+		return "ecdsa-sk"
+	case strings.HasPrefix(t, "ecdsa-"):
+		return "ecdsa"
+	default:
+		return t
+	}
+}
+
+// Bits returns the size of an RSA key's modulus, or zero for other key types.
+func (k *Key) Bits() int {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.PublicKey))
+	if err != nil {
+		return 0
+	}
+
+	ck, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+
+	if rk, ok := ck.CryptoPublicKey().(*rsa.PublicKey); ok {
+		return rk.N.BitLen()
+	}
+
+	return 0
+}
+
+// Scoped determines whether this key is limited to specific hosts or groups
+func (k *Key) Scoped() bool {
+	return len(k.Hosts) > 0 || len(k.Groups) > 0
+}
+
+// AppliesTo determines whether this key should be served to the named host,
+// which is a member of the provided groups.
+func (k *Key) AppliesTo(host string, groups []string) bool {
+	if !k.Scoped() {
+		return true
+	}
+
+	for _, h := range k.Hosts {
+		if host != "" && h == host {
+			return true
+		}
+	}
+
+	for _, g := range k.Groups {
+		for _, hg := range groups {
+			if g == hg {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (k *Key) Equals(key *Key) bool {
 	return k.User == key.User && k.PublicKey == key.PublicKey
 }
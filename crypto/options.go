@@ -0,0 +1,163 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// authorizedKeyOptions lists the OpenSSH authorized_keys options which may
+// be attached to a key, and whether each of them requires a value.
+// Options like cert-authority, which change the meaning of the key itself,
+// are deliberately not supported.
+var authorizedKeyOptions = map[string]bool{
+	"agent-forwarding":    false,
+	"command":             true,
+	"environment":         true,
+	"expiry-time":         true,
+	"from":                true,
+	"no-agent-forwarding": false,
+	"no-port-forwarding":  false,
+	"no-pty":              false,
+	"no-touch-required":   false,
+	"no-user-rc":          false,
+	"no-x11-forwarding":   false,
+	"permitlisten":        true,
+	"permitopen":          true,
+	"port-forwarding":     false,
+	"pty":                 false,
+	"restrict":            false,
+	"tunnel":              true,
+	"user-rc":             false,
+	"verify-required":     false,
+	"x11-forwarding":      false,
+}
+
+// permissiveOptions are those which re-enable features disabled by restrict
+var permissiveOptions = []string{
+	"agent-forwarding",
+	"port-forwarding",
+	"pty",
+	"user-rc",
+	"x11-forwarding",
+}
+
+// OptionName returns the lowercase name of an authorized_keys option
+func OptionName(option string) string {
+	return strings.ToLower(strings.SplitN(option, "=", 2)[0])
+}
+
+// ValidateOption checks that an option is one which Inki supports and
+// that its value is correctly quoted.
+func ValidateOption(option string) error {
+	name := OptionName(option)
+	hasValue, ok := authorizedKeyOptions[name]
+	if !ok {
+		return fmt.Errorf("unsupported option '%s'", name)
+	}
+
+	parts := strings.SplitN(option, "=", 2)
+	if !hasValue {
+		if len(parts) > 1 {
+			return fmt.Errorf("option '%s' does not accept a value", name)
+		}
+
+		return nil
+	}
+
+	if len(parts) < 2 {
+		return fmt.Errorf("option '%s' requires a value", name)
+	}
+
+	value, err := OptionValue(option)
+	if err != nil {
+		return fmt.Errorf("the value of option '%s' %s", name, err)
+	}
+
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("the value of option '%s' may not contain line breaks", name)
+	}
+
+	return nil
+}
+
+// OptionValue returns the unquoted value of an authorized_keys option, or an
+// empty string if it has none. Values are read in the same way as sshd does:
+// they must be wrapped in double quotes, and a backslash only escapes a
+// double quote, any other backslash is part of the value.
+func OptionValue(option string) (string, error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) < 2 {
+		return "", nil
+	}
+
+	quoted := parts[1]
+	if len(quoted) < 2 || quoted[0] != '"' {
+		return "", fmt.Errorf("must be quoted")
+	}
+
+	value := []byte{}
+	for i := 1; i < len(quoted); i++ {
+		switch {
+		case quoted[i] == '\\' && i+1 < len(quoted) && quoted[i+1] == '"':
+			value = append(value, '"')
+			i++
+		case quoted[i] == '"':
+			if i != len(quoted)-1 {
+				return "", fmt.Errorf("contains an unescaped quote")
+			}
+
+			return string(value), nil
+		default:
+			value = append(value, quoted[i])
+		}
+	}
+
+	return "", fmt.Errorf("is missing its closing quote")
+}
+
+// MergeOptions combines the options requested for a key with those which
+// are forced upon it. Forced options take precedence, replacing requested
+// options with the same name as well as any requested options which would
+// undo their effect (such as pty when no-pty or restrict is forced).
+func MergeOptions(requested, forced []string) []string {
+	blocked := map[string]bool{}
+	for _, o := range forced {
+		name := OptionName(o)
+		blocked[name] = true
+
+		if strings.HasPrefix(name, "no-") {
+			blocked[strings.TrimPrefix(name, "no-")] = true
+		} else {
+			blocked["no-"+name] = true
+		}
+
+		if name == "restrict" {
+			for _, p := range permissiveOptions {
+				blocked[p] = true
+			}
+		}
+	}
+
+	merged := []string{}
+	for _, o := range requested {
+		if !blocked[OptionName(o)] {
+			merged = append(merged, o)
+		}
+	}
+
+	for _, o := range forced {
+		exists := false
+		for _, m := range merged {
+			if m == o {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			merged = append(merged, o)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,33 @@
+package crypto
+
+import "testing"
+
+func TestOptionValue(t *testing.T) {
+	cases := []struct {
+		option string
+		value  string
+		valid  bool
+	}{
+		{`restrict`, "", true},
+		{`command=""`, "", true},
+		{`command="echo \n"`, `echo \n`, true},
+		{`command="echo \\"`, "", false},
+		{`command="echo \"hi\""`, `echo "hi"`, true},
+		{`command="C:\\Tools\\run.exe"`, `C:\\Tools\\run.exe`, true},
+		{`command=echo`, "", false},
+		{`command="echo`, "", false},
+		{`command="echo \"`, "", false},
+		{`command="echo "hi""`, "", false},
+	}
+
+	for _, c := range cases {
+		value, err := OptionValue(c.option)
+		if c.valid && err != nil {
+			t.Errorf("expected '%s' to be valid but got: %s", c.option, err)
+		} else if !c.valid && err == nil {
+			t.Errorf("expected '%s' to be rejected but got %q", c.option, value)
+		} else if c.valid && value != c.value {
+			t.Errorf("expected '%s' to have the value %q but got %q", c.option, c.value, value)
+		}
+	}
+}
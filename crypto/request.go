@@ -12,9 +12,12 @@ import (
 	"golang.org/x/crypto/openpgp/packet"
 )
 
+// Request is a signed request, either clearsigned with PGP or followed by an
+// SSH signature in the SSHSIG format.
 type Request struct {
-	Payload   []byte
-	Signature *armor.Block
+	Payload      []byte
+	Signature    *armor.Block
+	SSHSignature *SSHSignature
 }
 
 func (r *Request) EncodeJSON(from interface{}) error {
@@ -35,6 +38,14 @@ func (r *Request) DecodeJSON(into interface{}) error {
 func ReadRequests(data []byte) ([]Request, error) {
 	reqs := []Request{}
 	for d := data; len(d) > 0; {
+		if r, rest, err := readSSHRequest(d); err != nil {
+			return nil, err
+		} else if r != nil {
+			reqs = append(reqs, *r)
+			d = rest
+			continue
+		}
+
 		b, r := clearsign.Decode(d)
 		if b == nil {
 			break
@@ -54,6 +65,54 @@ func ReadRequests(data []byte) ([]Request, error) {
 	return reqs, nil
 }
 
+// readSSHRequest reads a request made up of its payload followed by an armored
+// SSH signature of it. If the next request is clearsigned then nil is returned.
+func readSSHRequest(data []byte) (*Request, []byte, error) {
+	// The armor must start on its own line, so that a payload which mentions
+	// it (in a JSON string, for example) isn't mistaken for its signature.
+	start := indexLine(data, []byte(sshSigBegin))
+	if start < 0 || bytes.Contains(data[:start], []byte("-----BEGIN PGP SIGNED MESSAGE-----")) {
+		return nil, data, nil
+	}
+
+	end := bytes.Index(data[start:], []byte(sshSigEnd))
+	if end < 0 {
+		return nil, data, fmt.Errorf("the SSH signature is not correctly armored")
+	}
+	end += start + len(sshSigEnd)
+
+	sig, err := ParseSSHSignature(data[start:end])
+	if err != nil {
+		return nil, data, err
+	}
+
+	// Whitespace between requests is not part of the following payload
+	rest := bytes.TrimLeft(data[end:], "\r\n")
+
+	return &Request{
+		Payload:      data[:start],
+		SSHSignature: sig,
+	}, rest, nil
+}
+
+// indexLine returns the index of the first line in data which starts with the
+// prefix, or -1 if there is none.
+func indexLine(data, prefix []byte) int {
+	for i := 0; i < len(data); {
+		if bytes.HasPrefix(data[i:], prefix) {
+			return i
+		}
+
+		n := bytes.IndexByte(data[i:], '\n')
+		if n < 0 {
+			break
+		}
+		i += n + 1
+	}
+
+	return -1
+}
+
 func WriteRequests(reqs []Request, key *packet.PrivateKey) ([]byte, error) {
 	out := bytes.NewBuffer([]byte{})
 
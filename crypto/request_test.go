@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestReadRequestsMixed(t *testing.T) {
+	entity, err := openpgp.NewEntity("Alice", "", "alice@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := newTestSSHSigner(t)
+	signSSH := func(payload string) []byte {
+		sig, err := SignSSH(signer, []byte(payload), SSHSignatureNamespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return append([]byte(payload), sig...)
+	}
+
+	signPGP := func(payload string) []byte {
+		b := bytes.NewBuffer([]byte{})
+		w, err := clearsign.Encode(b, entity.PrivateKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(payload))
+		w.Close()
+		b.WriteString("\n")
+		return b.Bytes()
+	}
+
+	payloads := []string{
+		`{"user":"first"}` + "\n",
+		`{"user":"second"}` + "\n",
+		`{"user":"third"}` + "\n",
+		`{"user":"fourth"}` + "\n",
+	}
+
+	body := bytes.Join([][]byte{
+		signSSH(payloads[0]),
+		signPGP(payloads[1]),
+		signPGP(payloads[2]),
+		signSSH(payloads[3]),
+	}, nil)
+
+	reqs, err := ReadRequests(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != len(payloads) {
+		t.Fatalf("expected %d requests but got %d", len(payloads), len(reqs))
+	}
+
+	for i, r := range reqs {
+		ssh := i == 0 || i == 3
+		if ssh {
+			if r.SSHSignature == nil || r.Signature != nil {
+				t.Errorf("expected request %d to be signed with SSH", i+1)
+				continue
+			}
+
+			if err := r.SSHSignature.Verify(r.Payload, SSHSignatureNamespace); err != nil {
+				t.Errorf("expected request %d to have a valid signature but got: %s", i+1, err)
+			}
+		} else if r.Signature == nil || r.SSHSignature != nil {
+			t.Errorf("expected request %d to be signed with PGP", i+1)
+			continue
+		} else if _, err := openpgp.CheckDetachedSignature(openpgp.EntityList{entity}, bytes.NewReader(r.Payload), r.Signature.Body); err != nil {
+			t.Errorf("expected request %d to have a valid signature but got: %s", i+1, err)
+		}
+
+		// Clearsigning removes the payload's trailing newline
+		if p := string(bytes.TrimSpace(r.Payload)); p != string(bytes.TrimSpace([]byte(payloads[i]))) {
+			t.Errorf("expected request %d to have the payload %q but got %q", i+1, payloads[i], p)
+		}
+	}
+}
+
+func TestReadRequestsPayloadContainingArmor(t *testing.T) {
+	signer := newTestSSHSigner(t)
+	payload := []byte(`{"user":"root","comment":"-----BEGIN SSH SIGNATURE-----"}` + "\n")
+
+	sig, err := SignSSH(signer, payload, SSHSignatureNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqs, err := ReadRequests(append(payload, sig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reqs) != 1 {
+		t.Fatalf("expected a single request but got %d", len(reqs))
+	}
+
+	if !bytes.Equal(reqs[0].Payload, payload) {
+		t.Errorf("expected the payload %q but got %q", payload, reqs[0].Payload)
+	}
+
+	if err := reqs[0].SSHSignature.Verify(reqs[0].Payload, SSHSignatureNamespace); err != nil {
+		t.Errorf("expected the signature to be valid but got: %s", err)
+	}
+
+}
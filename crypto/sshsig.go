@@ -0,0 +1,228 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHSignatureNamespace is the namespace which requests are signed for, as
+// with ssh-keygen -Y sign -n inki
+const SSHSignatureNamespace = "inki"
+
+const (
+	sshSigMagic = "SSHSIG"
+	sshSigBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSigEnd   = "-----END SSH SIGNATURE-----"
+)
+
+// SSHSignature is a signature in OpenSSH's SSHSIG format, as produced by
+// ssh-keygen -Y sign.
+type SSHSignature struct {
+	PublicKey     ssh.PublicKey
+	Namespace     string
+	HashAlgorithm string
+	Signature     *ssh.Signature
+}
+
+type sshSigBlob struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSigSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// ParseSSHSignature decodes an armored SSH signature
+func ParseSSHSignature(armored []byte) (*SSHSignature, error) {
+	text := strings.TrimSpace(string(armored))
+	if !strings.HasPrefix(text, sshSigBegin) || !strings.HasSuffix(text, sshSigEnd) {
+		return nil, fmt.Errorf("the SSH signature is not correctly armored")
+	}
+
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshSigBegin), sshSigEnd)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(sshSigMagic)) {
+		return nil, fmt.Errorf("the SSH signature does not use the SSHSIG format")
+	}
+
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(data[len(sshSigMagic):], &blob); err != nil {
+		return nil, err
+	}
+
+	if blob.Version != 1 {
+		return nil, fmt.Errorf("version %d SSH signatures are not supported", blob.Version)
+	}
+
+	pub, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &ssh.Signature{}
+	if err := ssh.Unmarshal(blob.Signature, sig); err != nil {
+		return nil, err
+	}
+
+	return &SSHSignature{
+		PublicKey:     pub,
+		Namespace:     blob.Namespace,
+		HashAlgorithm: blob.HashAlgorithm,
+		Signature:     sig,
+	}, nil
+}
+
+// Verify checks that this is a valid signature of the message, made for the
+// given namespace by the signature's public key.
+func (s *SSHSignature) Verify(message []byte, namespace string) error {
+	if s.Namespace != namespace {
+		return fmt.Errorf("the signature was made for the '%s' namespace rather than '%s'", s.Namespace, namespace)
+	}
+
+	// SHA-1 RSA signatures are not permitted by the SSHSIG format
+	if s.Signature.Format == ssh.KeyAlgoRSA {
+		return fmt.Errorf("ssh-rsa signatures are not supported, use rsa-sha2-512 instead")
+	}
+
+	data, err := sshSigSignedMessage(message, namespace, s.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	return s.PublicKey.Verify(data, s.Signature)
+}
+
+// SignSSH signs the message for the given namespace, returning an armored
+// SSHSIG signature.
+func SignSSH(signer ssh.Signer, message []byte, namespace string) ([]byte, error) {
+	data, err := sshSigSignedMessage(message, namespace, "sha512")
+	if err != nil {
+		return nil, err
+	}
+
+	var sig *ssh.Signature
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte(sshSigMagic), ssh.Marshal(&sshSigBlob{
+		Version:       1,
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	out := bytes.NewBufferString(sshSigBegin + "\n")
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n")
+	out.WriteString(sshSigEnd + "\n")
+
+	return out.Bytes(), nil
+}
+
+func sshSigSignedMessage(message []byte, namespace, hashAlgorithm string) ([]byte, error) {
+	var h hash.Hash
+	switch hashAlgorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("the %s hash algorithm is not supported", hashAlgorithm)
+	}
+
+	h.Write(message)
+
+	return append([]byte(sshSigMagic), ssh.Marshal(&sshSigSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: hashAlgorithm,
+		Hash:          h.Sum(nil),
+	})...), nil
+}
+
+var sshNamespacesOption = regexp.MustCompile(`namespaces="([^"]*)"`)
+
+// SigningKey is an SSH public key which is trusted to sign requests, the
+// identity describes its owner in logs.
+type SigningKey struct {
+	PublicKey ssh.PublicKey
+	Identity  string
+}
+
+// ParseSigningKey reads a trusted signing key from a line in the format used
+// by OpenSSH's allowed_signers file (principals, options and then the key) or
+// its authorized_keys file. Keys whose namespaces option doesn't include inki
+// are rejected.
+func ParseSigningKey(line string) (*SigningKey, error) {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		data, err := base64.StdEncoding.DecodeString(fields[i+1])
+		if err != nil {
+			continue
+		}
+
+		pub, err := ssh.ParsePublicKey(data)
+		if err != nil || pub.Type() != fields[i] {
+			continue
+		}
+
+		key := &SigningKey{
+			PublicKey: pub,
+			Identity:  strings.Join(fields[i+2:], " "),
+		}
+
+		options := fields[:i]
+		if len(options) > 0 && !strings.Contains(options[0], "=") {
+			key.Identity = options[0]
+			options = options[1:]
+		}
+
+		if m := sshNamespacesOption.FindStringSubmatch(strings.Join(options, " ")); m != nil {
+			allowed := false
+			for _, n := range strings.Split(m[1], ",") {
+				if n == SSHSignatureNamespace {
+					allowed = true
+				}
+			}
+
+			if !allowed {
+				return nil, fmt.Errorf("the key may not be used to sign for the '%s' namespace", SSHSignatureNamespace)
+			}
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("no SSH public key could be found")
+}
@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSSHSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer
+}
+
+func newTestRSASigner(t *testing.T) ssh.AlgorithmSigner {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signer.(ssh.AlgorithmSigner)
+}
+
+func signAndParse(t *testing.T, signer ssh.Signer, message []byte, namespace string) *SSHSignature {
+	armored, err := SignSSH(signer, message, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := ParseSSHSignature(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func TestSSHSignatureVerify(t *testing.T) {
+	message := []byte(`{"user":"root"}` + "\n")
+
+	for name, signer := range map[string]ssh.Signer{
+		"ed25519": newTestSSHSigner(t),
+		"rsa":     newTestRSASigner(t),
+	} {
+		sig := signAndParse(t, signer, message, SSHSignatureNamespace)
+		if err := sig.Verify(message, SSHSignatureNamespace); err != nil {
+			t.Errorf("%s: expected the signature to be valid but got: %s", name, err)
+		}
+
+		if err := sig.Verify([]byte(`{"user":"admin"}`+"\n"), SSHSignatureNamespace); err == nil {
+			t.Errorf("%s: expected the signature not to be valid for another message", name)
+		}
+	}
+}
+
+func TestSSHSignatureWrongNamespace(t *testing.T) {
+	message := []byte(`{"user":"root"}` + "\n")
+	sig := signAndParse(t, newTestSSHSigner(t), message, "git")
+
+	if err := sig.Verify(message, SSHSignatureNamespace); err == nil {
+		t.Error("expected a signature made for another namespace to be rejected")
+	}
+
+	// Changing the namespace doesn't change what was signed
+	sig.Namespace = SSHSignatureNamespace
+	if err := sig.Verify(message, SSHSignatureNamespace); err == nil {
+		t.Error("expected a signature whose namespace was altered to be rejected")
+	}
+}
+
+func TestSSHSignatureRejectsSHA1(t *testing.T) {
+	message := []byte(`{"user":"root"}` + "\n")
+	signer := newTestRSASigner(t)
+
+	data, err := sshSigSignedMessage(message, SSHSignatureNamespace, "sha512")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := signer.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := &SSHSignature{
+		PublicKey:     signer.PublicKey(),
+		Namespace:     SSHSignatureNamespace,
+		HashAlgorithm: "sha512",
+		Signature:     s,
+	}
+
+	if err := sig.Verify(message, SSHSignatureNamespace); err == nil {
+		t.Error("expected an ssh-rsa signature to be rejected")
+	}
+
+	if sig := signAndParse(t, signer, message, SSHSignatureNamespace); sig.Signature.Format != ssh.KeyAlgoRSASHA512 {
+		t.Errorf("expected RSA keys to sign with %s but got %s", ssh.KeyAlgoRSASHA512, sig.Signature.Format)
+	}
+}
+
+func TestParseSigningKey(t *testing.T) {
+	pub := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(newTestSSHSigner(t).PublicKey())))
+
+	cases := []struct {
+		line     string
+		identity string
+		valid    bool
+	}{
+		{pub + " alice@laptop", "alice@laptop", true},
+		{"alice@example.com " + pub, "alice@example.com", true},
+		{`alice@example.com namespaces="git,inki" ` + pub, "alice@example.com", true},
+		{`namespaces="inki" ` + pub + " alice@laptop", "alice@laptop", true},
+		{`alice@example.com namespaces="git,file" ` + pub, "", false},
+		{`namespaces="inkix" ` + pub, "", false},
+		{"alice@example.com", "", false},
+	}
+
+	for _, c := range cases {
+		key, err := ParseSigningKey(c.line)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("expected '%s' to be rejected", c.line)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("expected '%s' to be accepted but got: %s", c.line, err)
+			continue
+		}
+
+		if key.Identity != c.identity {
+			t.Errorf("expected '%s' to have the identity '%s' but got '%s'", c.line, c.identity, key.Identity)
+		}
+	}
+}
@@ -50,6 +50,8 @@ func main() {
 	app.Commands = []cli.Command{
 		server.Command,
 		client.KeysCommands,
+		client.AgentCommand,
+		client.AuthorizedKeysCommand,
 	}
 
 	err := app.Run(os.Args)
@@ -2,7 +2,12 @@ package server
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/SierraSoftworks/girder"
 	"github.com/SierraSoftworks/girder/errors"
@@ -10,6 +15,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
 )
 
 var router = mux.NewRouter()
@@ -17,6 +23,10 @@ var router = mux.NewRouter()
 func init() {
 	router.NotFoundHandler = notFoundHandler
 	router.StrictSlash(true)
+
+	// SHA256 fingerprints are base64 encoded and may contain "//", which
+	// would otherwise be cleaned from the path and answered with a redirect.
+	router.SkipClean(true)
 }
 
 var notFoundHandler = girder.NewHandler(func(c *girder.Context) (interface{}, error) {
@@ -60,43 +70,272 @@ func init() {
 		Name("GET /user/{user}/authorized_keys")
 
 	Router().
-		Path("/v1/user/{user}/key/{fingerprint}").
+		Path("/v1/user/{user}/key/{fingerprint:.+}").
 		Methods("GET").
 		Handler(girder.NewHandler(getKeyForUser)).
 		Name("GET /user/{user}/key/{fingerprint}")
+
+	Router().
+		Path("/v1/user/{user}/key/{fingerprint:.+}").
+		Methods("DELETE").
+		Handler(girder.NewHandler(removeKeyForUser)).
+		Name("DELETE /user/{user}/key/{fingerprint}")
+
+	Router().
+		Path("/v1/ca.pub").
+		Methods("GET").
+		Handler(girder.NewHandler(getCAPublicKey)).
+		Name("GET /ca.pub")
+
+	Router().
+		Path("/v1/ca.krl").
+		Methods("GET").
+		Handler(girder.NewHandler(getCARevocationList)).
+		Name("GET /ca.krl")
+
+	Router().
+		Path("/v1/pending").
+		Methods("GET").
+		Handler(girder.NewHandler(getPendingKeys)).
+		Name("GET /pending")
+
+	Router().
+		Path("/v1/pending/{id}").
+		Methods("GET").
+		Handler(girder.NewHandler(getPendingKey)).
+		Name("GET /pending/{id}")
+
+	Router().
+		Path("/v1/pending/{id}/approve").
+		Methods("POST").
+		Handler(girder.NewHandler(approvePendingKey)).
+		Name("POST /pending/{id}/approve")
 }
 
 func getAllKeys(c *girder.Context) (interface{}, error) {
-	return GetAllKeys(), nil
+	host, err := authenticateHost(c.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	// The revision is read before the keys so that clients which watch for
+	// changes from it will not miss any which are made in the meantime.
+	c.ResponseHeaders.Set("X-Inki-Revision", strconv.FormatUint(CurrentRevision(), 10))
+
+	pred := KeyPredicate(func(k *crypto.Key) bool {
+		return host.CanRead(k.User)
+	})
+
+	// Hosts which cannot be identified are only given keys which have not
+	// been scoped to specific hosts or groups.
+	name, groups := requestingHost(c.Request, host)
+	pred = pred.And(KeyAppliesTo(name, groups))
+
+	keys, err := GetKeysBy(pred)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return nil, errors.ServerError()
+	}
+
+	return withUserOptions(keys), nil
 }
 
 func getKeysForUser(c *girder.Context) (interface{}, error) {
-	return GetKeysBy(UserEquals(c.Vars["user"])), nil
+	host, err := authorizeRead(c, c.Vars["user"])
+	if err != nil {
+		return nil, err
+	}
+
+	c.ResponseHeaders.Set("X-Inki-Revision", strconv.FormatUint(CurrentRevision(), 10))
+
+	pred := UserEquals(c.Vars["user"])
+	// Hosts which cannot be identified are only given keys which have not
+	// been scoped to specific hosts or groups.
+	name, groups := requestingHost(c.Request, host)
+	pred = pred.And(KeyAppliesTo(name, groups))
+
+	keys, err := GetKeysBy(pred)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return nil, errors.ServerError()
+	}
+
+	return withUserOptions(keys), nil
 }
 
 func getAuthorizedKeysForUser(c *girder.Context) (interface{}, error) {
-	keys := GetKeysBy(UserEquals(c.Vars["user"]))
+	host, err := authorizeRead(c, c.Vars["user"])
+	if err != nil {
+		return nil, err
+	}
+
+	c.ResponseHeaders.Set("X-Inki-Revision", strconv.FormatUint(CurrentRevision(), 10))
+
+	fingerprint, err := requestedFingerprint(c.Request)
+	if err != nil {
+		log.WithError(err).Warn("Failed to parse the requested key")
+		return nil, errors.BadRequest()
+	}
+
+	// Hosts which cannot be identified are only given keys which have not
+	// been scoped to specific hosts or groups.
+	name, groups := requestingHost(c.Request, host)
+	pred := KeyAppliesTo(name, groups)
+
+	ev := NewAuditEvent(c, "lookup")
+	ev.User = c.Vars["user"]
+	ev.Host = name
+
+	var keys []crypto.Key
+	if fingerprint != "" {
+		// When sshd tells us which key is being used we can record exactly
+		// which key was used for the login.
+		ev.Fingerprint = crypto.NormalizeFingerprint(fingerprint)
+		keys, err = GetKeysByFingerprint(c.Vars["user"], fingerprint)
+	} else {
+		keys, err = GetKeysBy(UserEquals(c.Vars["user"]))
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return nil, errors.ServerError()
+	}
 
 	b := bytes.NewBuffer([]byte{})
-	for _, k := range keys {
+	for _, k := range withUserOptions(keys) {
+		if !pred(&k) {
+			continue
+		}
+
 		if err := k.Validate(); err == nil {
-			b.WriteString(fmt.Sprintf("%s\n", k.PublicKey))
+			b.WriteString(fmt.Sprintf("%s\n", k.AuthorizedKey()))
+			ev.Fingerprints = append(ev.Fingerprints, k.Fingerprint())
 		}
 	}
 
+	ev.Outcome = "served"
+	if fingerprint != "" {
+		if len(ev.Fingerprints) == 0 {
+			ev.Outcome = "not_found"
+		} else {
+			ev.Fingerprint = ev.Fingerprints[0]
+		}
+	}
+	WriteAuditEvent(ev)
+
 	c.ResponseHeaders.Set("Content-Type", "text/plain")
 	c.Formatter = &StringFormatter{}
 
 	return b.String(), nil
 }
 
-func getKeyForUser(c *girder.Context) (interface{}, error) {
-	k := GetKeyBy(UserEquals(c.Vars["user"]).And(FingerprintEquals(c.Vars["fingerprint"])))
-	if k == nil {
+// requestedFingerprint returns the fingerprint of the key which the client is
+// asking for, either directly through the fingerprint parameter or from the
+// base64 encoded public key in the key parameter (sshd's %f and %k tokens).
+func requestedFingerprint(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	if fingerprint := q.Get("fingerprint"); fingerprint != "" {
+		return fingerprint, nil
+	}
+
+	if key := q.Get("key"); key != "" {
+		data, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return "", err
+		}
+
+		pub, err := ssh.ParsePublicKey(data)
+		if err != nil {
+			return "", err
+		}
+
+		return ssh.FingerprintSHA256(pub), nil
+	}
+
+	return "", nil
+}
+
+// withUserOptions applies the options from each user's configuration to their
+// keys, so that changes to them take effect for keys which have already been
+// issued and clients see the options which will actually be used.
+func withUserOptions(keys []crypto.Key) []crypto.Key {
+	cfg := GetConfig()
+	for i := range keys {
+		if user := cfg.GetUser(keys[i].User); user != nil && len(user.Options) > 0 {
+			keys[i].Options = crypto.MergeOptions(keys[i].Options, user.Options)
+		}
+	}
+
+	return keys
+}
+
+func getCAPublicKey(c *girder.Context) (interface{}, error) {
+	ca := GetConfig().CA
+	if !ca.Enabled() {
 		return nil, errors.NotFound()
 	}
 
-	return k, nil
+	signer, err := ca.Signer()
+	if err != nil {
+		log.WithError(err).Error("Failed to load the certificate authority's private key")
+		return nil, errors.ServerError()
+	}
+
+	c.ResponseHeaders.Set("Content-Type", "text/plain")
+	c.Formatter = &StringFormatter{}
+
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), nil
+}
+
+// getCARevocationList serves the certificates which hosts should no longer
+// accept as a KRL, for use as sshd's RevokedKeys file.
+func getCARevocationList(c *girder.Context) (interface{}, error) {
+	if ca := GetConfig().CA; !ca.Enabled() {
+		return nil, errors.NotFound()
+	}
+
+	revoked, err := GetRevokedCertificates()
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve revoked certificates from the key store")
+		return nil, errors.ServerError()
+	}
+
+	krl, err := MarshalKRL(revoked, CurrentRevision(), "Inki")
+	if err != nil {
+		log.WithError(err).Error("Failed to produce the key revocation list")
+		return nil, errors.ServerError()
+	}
+
+	c.ResponseHeaders.Set("Content-Type", "application/octet-stream")
+	c.Formatter = &StringFormatter{}
+
+	return string(krl), nil
+}
+
+func getKeyForUser(c *girder.Context) (interface{}, error) {
+	host, err := authorizeRead(c, c.Vars["user"])
+	if err != nil {
+		return nil, err
+	}
+
+	// Hosts which cannot be identified are only given keys which have not
+	// been scoped to specific hosts or groups.
+	name, groups := requestingHost(c.Request, host)
+	pred := KeyAppliesTo(name, groups)
+
+	keys, err := GetKeysByFingerprint(c.Vars["user"], c.Vars["fingerprint"])
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return nil, errors.ServerError()
+	}
+
+	for _, k := range withUserOptions(keys) {
+		if pred(&k) {
+			return &k, nil
+		}
+	}
+
+	return nil, errors.NotFound()
 }
 
 func addKey(c *girder.Context) (interface{}, error) {
@@ -106,18 +345,32 @@ func addKey(c *girder.Context) (interface{}, error) {
 	reqs, err := crypto.ReadRequests(d.Bytes())
 	if err != nil {
 		log.WithError(err).Warn("Failed to decode armored request data")
-		return nil, errors.BadRequest()
+		return nil, NewAuditEvent(c, "add").Reject("bad_request", errors.BadRequest())
 	}
 
+	// The configuration is read once so that a reload part way through the
+	// request can't change (or remove) the user being checked.
+	cfg := GetConfig()
+
+	// Every request is checked before any of the keys are activated or held
+	// for approval, so that an invalid request rejects the whole batch.
 	keys := []crypto.Key{}
+	events := []*AuditEvent{}
+	payloads := [][]byte{}
 	for _, r := range reqs {
+		ev := NewAuditEvent(c, "add")
+
 		var key crypto.Key
 		err := r.DecodeJSON(&key)
 		if err != nil {
 			log.WithError(err).Warn("Failed to decode JSON in request body")
-			return nil, errors.BadRequest()
+			return nil, ev.Reject("bad_request", errors.BadRequest())
 		}
 
+		ev.SetKey(&key)
+		key.PendingID = ""
+		key.Certificate = ""
+
 		log.WithFields(log.Fields{
 			"user":   key.User,
 			"expire": key.Expires,
@@ -126,33 +379,77 @@ func addKey(c *girder.Context) (interface{}, error) {
 
 		if err := key.Validate(); err != nil {
 			log.WithError(err).Warn("Key data was not in a valid format, or has expired")
-			return nil, errors.BadRequest()
+			if key.Expires.Before(time.Now()) {
+				return nil, ev.Reject("expired", errors.BadRequest())
+			}
+
+			return nil, ev.Reject("invalid_key", errors.BadRequest())
 		}
 
-		user := GetConfig().GetUser(key.User)
-		if user == nil {
-			log.WithField("user", key.User).Warn("No configuration entry for this user")
-			return nil, errors.NotAllowed()
+		user := cfg.GetUser(key.User)
+		if err := verifyRequest(&r, user, ev); err != nil {
+			return nil, err
 		}
 
-		kr, err := user.GetKeyRing()
+		active, err := policyKeys(key.User)
 		if err != nil {
-			log.WithError(err).Warn("Could not load user's keyring")
-			return nil, errors.ServerError()
+			log.WithError(err).Error("Failed to retrieve keys from the key store")
+			return nil, ev.Reject("server_error", errors.ServerError())
 		}
 
-		s := bytes.NewBuffer([]byte{})
-		s.ReadFrom(r.Signature.Body)
+		for _, k := range keys {
+			if k.User == key.User {
+				active = append(active, k)
+			}
+		}
 
-		signer, err := openpgp.CheckDetachedSignature(kr, bytes.NewBuffer(r.Payload), s)
-		if err != nil {
-			log.WithError(err).Warn("Failed to check request signature")
-			return nil, errors.Unauthorized()
+		if err := user.Policy.Check(&key, active, ev.SignerKeyID); err != nil {
+			log.WithError(err).WithField("user", key.User).Warn("Key was rejected by the user's policy")
+			return nil, ev.Reject("policy", errors.NewError(403, "Not Allowed", fmt.Sprintf("The key was rejected by the policy for this user: %s.", err)))
 		}
 
-		if signer == nil {
-			log.Warn("No signatory found for the request")
-			return nil, errors.Unauthorized()
+		key.Options = crypto.MergeOptions(key.Options, user.Options)
+
+		if hook := cfg.PolicyHook; hook.Enabled() {
+			decision, err := hook.Check(&PolicyHookRequest{
+				Key: key,
+				Signer: PolicyHookSigner{
+					KeyID: ev.SignerKeyID,
+					UID:   ev.SignerUID,
+				},
+				Client: PolicyHookClient{
+					SourceIP:  ev.SourceIP,
+					UserAgent: c.Request.UserAgent(),
+				},
+			})
+			if err != nil {
+				log.WithError(err).WithField("user", key.User).Error("Failed to check the key with the policy webhook")
+				return nil, ev.Reject("policy_hook_error", errors.ServerError())
+			}
+
+			if !decision.Allow {
+				log.WithField("user", key.User).WithField("reason", decision.Reason).Warn("Key was rejected by the policy webhook")
+				msg := "The key was rejected by the policy webhook."
+				if decision.Reason != "" {
+					msg = fmt.Sprintf("The key was rejected by the policy webhook: %s.", decision.Reason)
+				}
+				return nil, ev.Reject("policy_hook", errors.NewError(403, "Not Allowed", msg))
+			}
+
+			decision.Apply(&key)
+			ev.SetKey(&key)
+
+			if err := key.Validate(); err != nil {
+				log.WithError(err).WithField("user", key.User).Warn("Key was no longer valid after applying the policy webhook's overrides")
+				return nil, ev.Reject("policy_hook", errors.NewError(403, "Not Allowed", "The key was rejected by the policy webhook, its shortened expiry has already passed."))
+			}
+		}
+
+		if cfg.CA.Enabled() && !key.Scoped() {
+			if err := CheckCertificateOptions(key.Options); err != nil {
+				log.WithError(err).WithField("user", key.User).Warn("A certificate cannot be issued for the key")
+				return nil, ev.Reject("policy", errors.NewError(403, "Not Allowed", fmt.Sprintf("A certificate cannot be issued for the key: %s.", err)))
+			}
 		}
 
 		log.WithFields(log.Fields{
@@ -161,11 +458,420 @@ func addKey(c *girder.Context) (interface{}, error) {
 			"expire": key.Expires,
 		}).Debug("Accepted new key")
 		keys = append(keys, key)
+		events = append(events, ev)
+		payloads = append(payloads, r.Payload)
+	}
+
+	for i := range keys {
+		user := cfg.GetUser(keys[i].User)
+		if user.Approval.Required > 0 {
+			p := AddPendingKey(&keys[i], payloads[i], events[i], user.Approval.Required)
+			keys[i].PendingID = p.ID
+
+			log.WithFields(log.Fields{
+				"user": keys[i].User,
+				"id":   p.ID,
+			}).Info("Key is waiting for approval")
+
+			events[i].Pend()
+			continue
+		}
+
+		event := "key.added"
+		if exists, err := HasKey(&keys[i]); err == nil && exists {
+			event = "key.extended"
+		}
+
+		if err := activateKey(&keys[i], user, events[i].SignerKeyID); err != nil {
+			return nil, rejectActivation(events[i], err)
+		}
+
+		events[i].Accept()
+		Notify(NewNotification(event, events[i], &keys[i]))
+	}
+
+	return keys, nil
+}
+
+// policyError is returned when a key is no longer permitted by its user's
+// policy at the time it is activated.
+type policyError struct {
+	error
+}
+
+// policyKeys returns the keys which count towards the user's policy limits,
+// those which are active as well as those waiting to be approved.
+func policyKeys(user string) ([]crypto.Key, error) {
+	keys, err := GetKeysBy(UserEquals(user).And(KeyValid()))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range GetPendingKeys() {
+		if p.Key.User == user {
+			keys = append(keys, p.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+// rejectActivation rejects the event for a key which could not be activated
+func rejectActivation(ev *AuditEvent, err error) error {
+	if _, ok := err.(*policyError); ok {
+		return ev.Reject("policy", errors.NewError(403, "Not Allowed", fmt.Sprintf("The key could not be activated: %s.", err)))
+	}
+
+	return ev.Reject("server_error", errors.ServerError())
+}
+
+// activateKey checks the key against its user's policy once more, issues a
+// certificate for it if the server is acting as a certificate authority, and
+// adds it to the key store. The signer is the PGP key ID or SSH fingerprint
+// of the key which requested it.
+func activateKey(key *crypto.Key, user *ConfigUser, signer string) error {
+	if user == nil {
+		return &policyError{fmt.Errorf("the user '%s' is no longer configured", key.User)}
+	}
+
+	// Other keys may have been added (or approved) since this key was
+	// requested, or the policy may have been changed by a reload.
+	active, err := policyKeys(key.User)
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return err
+	}
+
+	if err := user.Policy.Check(key, active, signer); err != nil {
+		log.WithError(err).WithField("user", key.User).Warn("Key was rejected by the user's policy when it was activated")
+		return &policyError{err}
+	}
+
+	// Scoped keys are only served to the hosts they apply to, a certificate
+	// would let them be used on every host which trusts the CA.
+	if ca := GetConfig().CA; ca.Enabled() && !key.Scoped() {
+		signer, err := ca.Signer()
+		if err != nil {
+			log.WithError(err).Error("Failed to load the certificate authority's private key")
+			return err
+		}
+
+		cert, err := IssueCertificate(signer, key)
+		if err != nil {
+			log.WithError(err).WithField("user", key.User).Warn("Failed to issue a certificate for the key")
+			return err
+		}
+
+		key.Certificate = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert)))
+	}
+
+	if err := AddKey(key); err != nil {
+		log.WithError(err).Error("Failed to add key to the key store")
+		return err
+	}
+
+	return nil
+}
+
+func getPendingKeys(c *girder.Context) (interface{}, error) {
+	host, err := authenticateHost(c.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []crypto.PendingKey{}
+	for _, p := range GetPendingKeys() {
+		if host.CanRead(p.Key.User) {
+			keys = append(keys, p)
+		}
+	}
+
+	return keys, nil
+}
+
+func getPendingKey(c *girder.Context) (interface{}, error) {
+	p := GetPendingKey(c.Vars["id"])
+	if p == nil {
+		return nil, errors.NotFound()
+	}
+
+	if _, err := authorizeRead(c, p.Key.User); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func approvePendingKey(c *girder.Context) (interface{}, error) {
+	d := bytes.NewBuffer([]byte{})
+	d.ReadFrom(c.Request.Body)
+
+	ev := NewAuditEvent(c, "approve")
+
+	p := GetPendingKey(c.Vars["id"])
+	if p == nil {
+		return nil, ev.Reject("not_found", errors.NotFound())
+	}
+
+	ev.SetKey(&p.Key)
+
+	reqs, err := crypto.ReadRequests(d.Bytes())
+	if err != nil || len(reqs) != 1 {
+		log.WithError(err).Warn("Failed to decode armored request data")
+		return nil, ev.Reject("bad_request", errors.BadRequest())
+	}
+
+	var approval crypto.Approval
+	if err := reqs[0].DecodeJSON(&approval); err != nil {
+		log.WithError(err).Warn("Failed to decode JSON in request body")
+		return nil, ev.Reject("bad_request", errors.BadRequest())
+	}
+
+	// As with revocations, the signed payload must name the request being
+	// approved so that it can't be used for anything else.
+	if approval.Approve != p.ID {
+		log.WithField("id", approval.Approve).Warn("Signed approval did not match the pending key being approved")
+		return nil, ev.Reject("bad_request", errors.BadRequest())
+	}
+
+	user := GetConfig().GetUser(p.Key.User)
+	if user == nil || user.Approval.Required == 0 {
+		log.WithField("user", p.Key.User).Warn("User is no longer configured to require approvals")
+		return nil, ev.Reject("unknown_user", errors.NotAllowed())
+	}
+
+	if reqs[0].SSHSignature != nil {
+		keys, err := user.Approval.GetSigningKeys()
+		if err != nil {
+			log.WithError(err).Warn("Could not load user's approver signing keys")
+			return nil, ev.Reject("server_error", errors.ServerError())
+		}
+
+		if err := verifySSHSignature(&reqs[0], keys, ev); err != nil {
+			return nil, err
+		}
+	} else {
+		kr, err := user.Approval.GetKeyRing()
+		if err != nil {
+			log.WithError(err).Warn("Could not load user's approver keyring")
+			return nil, ev.Reject("server_error", errors.ServerError())
+		}
+
+		if err := verifySignature(&reqs[0], kr, ev); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err = ApprovePendingKey(p.ID, ev)
+	if err != nil {
+		log.WithError(err).WithField("approver", signerIdentity(ev)).Warn("Approval was not accepted")
+		return nil, ev.Reject("policy", errors.NewError(403, "Not Allowed", fmt.Sprintf("The approval could not be accepted: %s.", err)))
+	}
+
+	if p == nil {
+		return nil, ev.Reject("not_found", errors.NotFound())
+	}
+
+	if p.Approved() {
+		if err := activateKey(&p.Key, user, p.RequestedBy); err != nil {
+			return nil, rejectActivation(ev, err)
+		}
+
+		RemovePendingKey(p.ID)
+
+		log.WithFields(log.Fields{
+			"user": p.Key.User,
+			"id":   p.ID,
+		}).Info("Key has been approved and is now active")
+	}
+
+	ev.Accept()
+	if p.Approved() {
+		Notify(NewNotification("key.added", ev, &p.Key))
+	}
+
+	return p, nil
+}
+
+func removeKeyForUser(c *girder.Context) (interface{}, error) {
+	d := bytes.NewBuffer([]byte{})
+	d.ReadFrom(c.Request.Body)
+
+	ev := NewAuditEvent(c, "remove")
+	ev.User = c.Vars["user"]
+	ev.Fingerprint = crypto.NormalizeFingerprint(c.Vars["fingerprint"])
+
+	reqs, err := crypto.ReadRequests(d.Bytes())
+	if err != nil {
+		log.WithError(err).Warn("Failed to decode armored request data")
+		return nil, ev.Reject("bad_request", errors.BadRequest())
+	}
+
+	if len(reqs) == 0 {
+		log.Warn("No signed revocation request was provided")
+		return nil, ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	for _, r := range reqs {
+		var revocation crypto.ShortKey
+		if err := r.DecodeJSON(&revocation); err != nil {
+			log.WithError(err).Warn("Failed to decode JSON in request body")
+			return nil, ev.Reject("bad_request", errors.BadRequest())
+		}
+
+		// The signed payload must describe exactly the key being removed, this
+		// prevents a signed request to add a key from being used to remove it
+		// (or the revocation for one key being used to remove another).
+		if revocation.User != c.Vars["user"] || crypto.NormalizeFingerprint(revocation.Fingerprint) != crypto.NormalizeFingerprint(c.Vars["fingerprint"]) {
+			log.WithFields(log.Fields{
+				"user":        revocation.User,
+				"fingerprint": revocation.Fingerprint,
+			}).Warn("Signed revocation request did not match the key being removed")
+			return nil, ev.Reject("bad_request", errors.BadRequest())
+		}
+
+		if err := verifyRequest(&r, GetConfig().GetUser(revocation.User), ev); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := RemoveKeyBy(UserEquals(c.Vars["user"]).And(FingerprintEquals(c.Vars["fingerprint"])))
+	if err != nil {
+		log.WithError(err).Error("Failed to remove keys from the key store")
+		return nil, ev.Reject("server_error", errors.ServerError())
+	}
+
+	if len(keys) == 0 {
+		return nil, ev.Reject("not_found", errors.NotFound())
 	}
 
 	for _, k := range keys {
-		AddKey(&k)
+		log.WithFields(log.Fields{
+			"user":        k.User,
+			"fingerprint": k.Fingerprint(),
+		}).Info("Revoked key")
+		ev.Fingerprints = append(ev.Fingerprints, k.Fingerprint())
+	}
+
+	ev.Accept()
+	for i := range keys {
+		n := NewNotification("key.revoked", ev, &keys[i])
+		n.Fingerprint = keys[i].Fingerprint()
+		Notify(n)
 	}
 
 	return keys, nil
 }
+
+// verifyRequest checks that the request has been signed by one of the keys
+// in the user's keyring, or one of their SSH signing keys, and that it is not
+// being replayed. The signer is recorded on the audit event, which is rejected
+// if verification fails (including when the user is not configured).
+func verifyRequest(r *crypto.Request, user *ConfigUser, ev *AuditEvent) error {
+	if user == nil {
+		log.WithField("user", ev.User).Warn("No configuration entry for this user")
+		return ev.Reject("unknown_user", errors.NotAllowed())
+	}
+
+	if r.SSHSignature != nil {
+		keys, err := user.GetSigningKeys()
+		if err != nil {
+			log.WithError(err).Warn("Could not load user's signing keys")
+			return ev.Reject("server_error", errors.ServerError())
+		}
+
+		return verifySSHSignature(r, keys, ev)
+	}
+
+	if user.KeyRing == "" {
+		log.WithField("user", user.Name).Warn("User has no keyring to check PGP signatures against")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	kr, err := user.GetKeyRing()
+	if err != nil {
+		log.WithError(err).Warn("Could not load user's keyring")
+		return ev.Reject("server_error", errors.ServerError())
+	}
+
+	return verifySignature(r, kr, ev)
+}
+
+// verifySignature checks that the request has been signed by one of the keys
+// in the keyring and that it is not being replayed.
+func verifySignature(r *crypto.Request, kr openpgp.KeyRing, ev *AuditEvent) error {
+	if r.Signature == nil {
+		log.Warn("Request was not signed with a PGP key")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	s := bytes.NewBuffer([]byte{})
+	s.ReadFrom(r.Signature.Body)
+
+	signer, err := openpgp.CheckDetachedSignature(kr, bytes.NewBuffer(r.Payload), s)
+	if err != nil {
+		log.WithError(err).Warn("Failed to check request signature")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	if signer == nil {
+		log.Warn("No signatory found for the request")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	ev.SetSigner(signer)
+
+	return verifyClaims(r, ev)
+}
+
+// verifySSHSignature checks that the request has been signed by one of the
+// trusted SSH keys and that it is not being replayed.
+func verifySSHSignature(r *crypto.Request, keys []*crypto.SigningKey, ev *AuditEvent) error {
+	if r.SSHSignature == nil {
+		log.Warn("Request was not signed with an SSH key")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	var signer *crypto.SigningKey
+	pub := r.SSHSignature.PublicKey.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.PublicKey.Marshal(), pub) {
+			signer = k
+			break
+		}
+	}
+
+	if signer == nil {
+		log.WithField("fingerprint", ssh.FingerprintSHA256(r.SSHSignature.PublicKey)).Warn("Request was signed by an SSH key which is not trusted")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	if err := r.SSHSignature.Verify(r.Payload, crypto.SSHSignatureNamespace); err != nil {
+		log.WithError(err).Warn("Failed to check request signature")
+		return ev.Reject("bad_signature", errors.Unauthorized())
+	}
+
+	ev.SetSSHSigner(signer)
+
+	return verifyClaims(r, ev)
+}
+
+// verifyClaims checks the request's claims to prevent it from being replayed
+func verifyClaims(r *crypto.Request, ev *AuditEvent) error {
+	var claims crypto.Claims
+	if err := r.DecodeJSON(&claims); err != nil {
+		log.WithError(err).Warn("Failed to decode request claims")
+		return ev.Reject("bad_request", errors.BadRequest())
+	}
+
+	if err := GetConfig().Requests.Check(&claims); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"issued_at": claims.IssuedAt,
+			"nonce":     claims.Nonce,
+			"audience":  claims.Audience,
+		}).Warn("Request failed replay protection checks")
+		return ev.Reject("replay", errors.NewError(401, "Unauthorized", fmt.Sprintf("The request could not be accepted: %s.", err)))
+	}
+
+	return nil
+}
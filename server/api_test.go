@@ -0,0 +1,665 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSigner is an SSH key which can sign requests in tests
+type testSigner struct {
+	signer ssh.Signer
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSigner{signer: signer}
+}
+
+// SigningKey returns the line used to trust this signer in the configuration
+func (s *testSigner) SigningKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.signer.PublicKey())))
+}
+
+// Sign encodes the payload, along with a fresh set of claims, and signs it
+func (s *testSigner) Sign(t *testing.T, payload interface{}) []byte {
+	claims, err := crypto.NewClaims("http://inki.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]interface{}{}
+	for _, part := range []interface{}{payload, claims} {
+		data, err := json.Marshal(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+
+	sig, err := crypto.SignSSH(s.signer, data, crypto.SSHSignatureNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(data, sig...)
+}
+
+// setupTestServer replaces the server's configuration, store and pending keys
+// for the duration of a test.
+func setupTestServer(t *testing.T, cfg *Config) func() {
+	if len(cfg.Requests.Audience) == 0 {
+		cfg.Requests.Audience = []string{"http://inki.test"}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldConfig := GetConfig()
+	oldStore := GetStore()
+
+	SetConfig(cfg)
+	store = NewMemoryKeyStore()
+
+	pendingKeysLock.Lock()
+	oldPending := pendingKeys
+	pendingKeys = map[string]pendingKey{}
+	pendingKeysLock.Unlock()
+
+	return func() {
+		SetConfig(oldConfig)
+		store = oldStore
+
+		pendingKeysLock.Lock()
+		pendingKeys = oldPending
+		pendingKeysLock.Unlock()
+	}
+}
+
+func serveTestRequest(method, url string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	res := httptest.NewRecorder()
+	Router().ServeHTTP(res, req)
+	return res
+}
+
+func TestAddKeyRejectsWholeBatch(t *testing.T) {
+	signer := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+			Approval: ConfigApproval{
+				Required:    1,
+				SigningKeys: []string{newTestSigner(t).SigningKey()},
+			},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	valid := newTestKey(t, "root")
+	expired := newTestKey(t, "root")
+	expired.Expires = time.Now().Add(-time.Minute)
+
+	body := append(signer.Sign(t, valid), signer.Sign(t, expired)...)
+	res := serveTestRequest("POST", "/v1/keys", body)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected the batch to be rejected but got %d: %s", res.Code, res.Body.String())
+	}
+
+	if pending := GetPendingKeys(); len(pending) != 0 {
+		t.Errorf("expected no keys to be held for approval but got %d", len(pending))
+	}
+}
+
+func TestAddKeyCountsPendingKeys(t *testing.T) {
+	signer := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+			Policy:      ConfigPolicy{MaxKeys: 1},
+			Approval: ConfigApproval{
+				Required:    1,
+				SigningKeys: []string{newTestSigner(t).SigningKey()},
+			},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	res := serveTestRequest("POST", "/v1/keys", signer.Sign(t, newTestKey(t, "root")))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the first key to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = serveTestRequest("POST", "/v1/keys", signer.Sign(t, newTestKey(t, "root")))
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected the pending key to count towards max_keys but got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestApproveKeyChecksPolicy(t *testing.T) {
+	signer := newTestSigner(t)
+	approver := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+			Policy:      ConfigPolicy{MaxKeys: 1},
+			Approval: ConfigApproval{
+				Required:    1,
+				SigningKeys: []string{approver.SigningKey()},
+			},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	key := newTestKey(t, "root")
+	res := serveTestRequest("POST", "/v1/keys", signer.Sign(t, key))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the key to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	keys := []crypto.Key{}
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil || len(keys) != 1 || keys[0].PendingID == "" {
+		t.Fatalf("expected the key to be pending but got %v (err: %v)", keys, err)
+	}
+	id := keys[0].PendingID
+
+	// Another key becomes active while this one waits for approval
+	if err := AddKey(newTestKey(t, "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	res = serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", id), approver.Sign(t, &crypto.Approval{Approve: id}))
+	if res.Code != http.StatusForbidden {
+		t.Fatalf("expected the approval to be rejected by the policy but got %d: %s", res.Code, res.Body.String())
+	}
+
+	if ok, _ := HasKey(key); ok {
+		t.Error("expected the key not to have been activated")
+	}
+}
+
+func TestApproveKeyActivatesKey(t *testing.T) {
+	signer := newTestSigner(t)
+	approver := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+			Approval: ConfigApproval{
+				Required:    1,
+				SigningKeys: []string{approver.SigningKey()},
+			},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	key := newTestKey(t, "root")
+	p := AddPendingKey(key, []byte("request"), &AuditEvent{SignerKeyID: ssh.FingerprintSHA256(signer.signer.PublicKey())}, 1)
+
+	// Only the configured approvers may approve keys
+	res := serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", p.ID), signer.Sign(t, &crypto.Approval{Approve: p.ID}))
+	if res.Code == http.StatusOK {
+		t.Fatal("expected the requester's own approval to be rejected")
+	}
+
+	res = serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", p.ID), approver.Sign(t, &crypto.Approval{Approve: p.ID}))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the approval to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	if ok, _ := HasKey(key); !ok {
+		t.Error("expected the key to have been activated")
+	}
+
+	if GetPendingKey(p.ID) != nil {
+		t.Error("expected the key to no longer be pending once it was activated")
+	}
+}
+
+func TestApproveKeyCountsPeople(t *testing.T) {
+	signer := newTestSigner(t)
+	approver := newTestSigner(t)
+	approverSecondKey := newTestSigner(t)
+	other := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{"jane@example.com " + signer.SigningKey()},
+			Approval: ConfigApproval{
+				Required: 2,
+				SigningKeys: []string{
+					"JANE@example.com " + approverSecondKey.SigningKey(),
+					"john@example.com " + approver.SigningKey(),
+					"john@example.com " + other.SigningKey(),
+				},
+			},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	key := newTestKey(t, "root")
+	p := AddPendingKey(key, []byte("request"), &AuditEvent{
+		SignerKeyID: ssh.FingerprintSHA256(signer.signer.PublicKey()),
+		SignerUID:   "jane@example.com",
+	}, 2)
+
+	res := serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", p.ID), approverSecondKey.Sign(t, &crypto.Approval{Approve: p.ID}))
+	if res.Code == http.StatusOK {
+		t.Fatal("expected the requester's approval with another of their keys to be rejected")
+	}
+
+	res = serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", p.ID), approver.Sign(t, &crypto.Approval{Approve: p.ID}))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the approval to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = serveTestRequest("POST", fmt.Sprintf("/v1/pending/%s/approve", p.ID), other.Sign(t, &crypto.Approval{Approve: p.ID}))
+	if res.Code == http.StatusOK {
+		t.Fatal("expected a second approval by the same person to be rejected")
+	}
+
+	if ok, _ := HasKey(key); ok {
+		t.Error("expected the key not to have been activated")
+	}
+
+	if pending := GetPendingKey(p.ID); pending == nil || len(pending.Approvals) != 1 {
+		t.Errorf("expected the key to remain pending with a single approval but got %v", pending)
+	}
+}
+
+func TestScopedKeysRequireHost(t *testing.T) {
+	defer setupTestServer(t, DefaultConfig())()
+
+	since := CurrentRevision()
+	unscoped := newTestKey(t, "root")
+	scoped := newTestKey(t, "root")
+	scoped.Hosts = []string{"db-01"}
+	for _, k := range []*crypto.Key{unscoped, scoped} {
+		if err := AddKey(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		url      string
+		expected []*crypto.Key
+	}{
+		{"/v1/keys", []*crypto.Key{unscoped}},
+		{"/v1/keys?host=web-01", []*crypto.Key{unscoped}},
+		{"/v1/keys?host=db-01", []*crypto.Key{unscoped, scoped}},
+		{"/v1/user/root/keys", []*crypto.Key{unscoped}},
+		{"/v1/user/root/keys?host=db-01", []*crypto.Key{unscoped, scoped}},
+	}
+
+	for _, c := range cases {
+		res := serveTestRequest("GET", c.url, nil)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: expected the keys to be returned but got %d: %s", c.url, res.Code, res.Body.String())
+		}
+
+		keys := []crypto.Key{}
+		err := json.NewDecoder(res.Body).Decode(&keys)
+		assertKeys(t, keys, err, c.expected...)
+	}
+
+	res := serveTestRequest("GET", fmt.Sprintf("/v1/user/root/key/%s", scoped.FingerprintMD5()), nil)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("expected the scoped key not to be returned to an unidentified host but got %d", res.Code)
+	}
+
+	res = serveTestRequest("GET", fmt.Sprintf("/v1/watch?since=%d&timeout=0s", since), nil)
+	watch := WatchResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&watch); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(watch.Changes) != 2 ||
+		watch.Changes[0].Type != "added" || !watch.Changes[0].Key.Equals(unscoped) ||
+		watch.Changes[1].Type != "removed" || watch.Changes[1].Key.PublicKey != scoped.PublicKey {
+		t.Errorf("expected the scoped key to be watched as removed by an unidentified host but got %v", watch.Changes)
+	}
+}
+
+func TestConfiguredHostMustAuthenticate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Hosts = []ConfigHost{
+		{Name: "db-01", Token: "db-01-token", Users: []string{"root"}, Groups: []string{"db"}},
+	}
+	defer setupTestServer(t, cfg)()
+
+	unscoped := newTestKey(t, "root")
+	scoped := newTestKey(t, "root")
+	scoped.Groups = []string{"db"}
+	for _, k := range []*crypto.Key{unscoped, scoped} {
+		if err := AddKey(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := serveTestRequest("GET", "/v1/keys?host=db-01", nil)
+	keys := []crypto.Key{}
+	err := json.NewDecoder(res.Body).Decode(&keys)
+	assertKeys(t, keys, err, unscoped)
+
+	req := httptest.NewRequest("GET", "/v1/keys", nil)
+	req.Header.Set("Authorization", "Bearer db-01-token")
+	rec := httptest.NewRecorder()
+	Router().ServeHTTP(rec, req)
+
+	keys = []crypto.Key{}
+	err = json.NewDecoder(rec.Body).Decode(&keys)
+	assertKeys(t, keys, err, unscoped, scoped)
+}
+
+func TestKeyFingerprintWithDoubleSlash(t *testing.T) {
+	signer := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	// Around one in a hundred SHA256 fingerprints contain "//"
+	key := newTestKey(t, "root")
+	for !strings.Contains(key.Fingerprint(), "//") {
+		key = newTestKey(t, "root")
+	}
+
+	if err := AddKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	url := fmt.Sprintf("/api/v1/user/root/key/%s", key.Fingerprint())
+	res := httptest.NewRecorder()
+	serverHandler().ServeHTTP(res, httptest.NewRequest("GET", url, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the key to be returned but got %d: %s", res.Code, res.Body.String())
+	}
+
+	revocation := signer.Sign(t, &crypto.ShortKey{User: "root", Fingerprint: key.Fingerprint()})
+	res = httptest.NewRecorder()
+	serverHandler().ServeHTTP(res, httptest.NewRequest("DELETE", url, bytes.NewReader(revocation)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the key to be removed but got %d: %s", res.Code, res.Body.String())
+	}
+
+	if ok, _ := HasKey(key); ok {
+		t.Error("expected the key to have been removed")
+	}
+}
+
+// newTestCA generates a certificate authority key for tests
+func newTestCA(t *testing.T) ConfigCA {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ConfigCA{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+	}
+}
+
+func TestAddKeyScopedWithoutCertificate(t *testing.T) {
+	signer := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.CA = newTestCA(t)
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	unscoped := newTestKey(t, "root")
+	scoped := newTestKey(t, "root")
+	scoped.Hosts = []string{"db-01"}
+
+	res := serveTestRequest("POST", "/v1/keys", append(signer.Sign(t, unscoped), signer.Sign(t, scoped)...))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the keys to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	keys := []crypto.Key{}
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil || len(keys) != 2 {
+		t.Fatalf("expected both keys to be returned but got %v (err: %v)", keys, err)
+	}
+
+	for _, k := range keys {
+		if k.Scoped() && k.Certificate != "" {
+			t.Errorf("expected no certificate to be issued for the scoped key but got %s", k.Certificate)
+		} else if !k.Scoped() && k.Certificate == "" {
+			t.Error("expected a certificate to be issued for the unscoped key")
+		}
+	}
+
+	stored, err := GetKeysByFingerprint("root", scoped.Fingerprint())
+	assertKeys(t, stored, err, scoped)
+	if stored[0].Certificate != "" {
+		t.Error("expected no certificate to be stored for the scoped key")
+	}
+}
+
+// parseTestKRL returns the serials revoked by a KRL, for each CA
+func parseTestKRL(t *testing.T, data []byte) map[string][]uint64 {
+	readString := func() []byte {
+		if len(data) < 4 || len(data) < 4+int(binary.BigEndian.Uint32(data)) {
+			t.Fatal("the KRL was truncated")
+		}
+
+		n := binary.BigEndian.Uint32(data)
+		s := data[4 : 4+n]
+		data = data[4+n:]
+		return s
+	}
+
+	if len(data) < 36 || binary.BigEndian.Uint64(data) != krlMagic {
+		t.Fatal("expected the KRL to start with its magic number")
+	}
+	data = data[36:]
+	readString()
+	readString()
+
+	serials := map[string][]uint64{}
+	for len(data) > 0 {
+		sectionType := data[0]
+		data = data[1:]
+		section := readString()
+		if sectionType != krlSectionCertificate {
+			t.Fatalf("unexpected KRL section %d", sectionType)
+		}
+
+		rest := data
+		data = section
+		ca := readString()
+		readString()
+		if data[0] != krlSectionCertSerials {
+			t.Fatalf("unexpected KRL certificate section %d", data[0])
+		}
+		data = data[1:]
+
+		list := readString()
+		for i := 0; i+8 <= len(list); i += 8 {
+			serials[string(ca)] = append(serials[string(ca)], binary.BigEndian.Uint64(list[i:]))
+		}
+		data = rest
+	}
+
+	return serials
+}
+
+func TestRemoveKeyRevokesCertificate(t *testing.T) {
+	signer := newTestSigner(t)
+	cfg := DefaultConfig()
+	cfg.CA = newTestCA(t)
+	cfg.Users = []ConfigUser{
+		{
+			Name:        "root",
+			SigningKeys: []string{signer.SigningKey()},
+		},
+	}
+	defer setupTestServer(t, cfg)()
+
+	ca, err := cfg.CA.Signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := newTestKey(t, "root")
+	res := serveTestRequest("POST", "/v1/keys", signer.Sign(t, key))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the key to be accepted but got %d: %s", res.Code, res.Body.String())
+	}
+
+	keys := []crypto.Key{}
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil || len(keys) != 1 || keys[0].Certificate == "" {
+		t.Fatalf("expected a certificate to be issued but got %v (err: %v)", keys, err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(keys[0].Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := pub.(*ssh.Certificate).Serial
+
+	res = serveTestRequest("GET", "/v1/ca.krl", nil)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the KRL to be returned but got %d: %s", res.Code, res.Body.String())
+	}
+
+	if revoked := parseTestKRL(t, res.Body.Bytes()); len(revoked) != 0 {
+		t.Fatalf("expected no certificates to be revoked yet but got %v", revoked)
+	}
+
+	revocation := signer.Sign(t, &crypto.ShortKey{User: "root", Fingerprint: key.FingerprintMD5()})
+	res = serveTestRequest("DELETE", fmt.Sprintf("/v1/user/root/key/%s", key.FingerprintMD5()), revocation)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the key to be removed but got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = serveTestRequest("GET", "/v1/ca.krl", nil)
+	revoked := parseTestKRL(t, res.Body.Bytes())[string(ca.PublicKey().Marshal())]
+	if len(revoked) != 1 || revoked[0] != serial {
+		t.Errorf("expected the removed key's certificate %d to be revoked but got %v", serial, revoked)
+	}
+}
+
+func TestWatchRescopedKey(t *testing.T) {
+	defer setupTestServer(t, DefaultConfig())()
+
+	key := newTestKey(t, "root")
+	key.Hosts = []string{"web-01"}
+	if err := AddKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	since := CurrentRevision()
+	rescoped := *key
+	rescoped.Hosts = []string{"web-02"}
+	if err := AddKey(&rescoped); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		host       string
+		changeType string
+	}{
+		{"web-01", "removed"},
+		{"web-02", "added"},
+	}
+
+	for _, c := range cases {
+		res := serveTestRequest("GET", fmt.Sprintf("/v1/watch?since=%d&timeout=0s&host=%s", since, c.host), nil)
+		watch := WatchResponse{}
+		if err := json.NewDecoder(res.Body).Decode(&watch); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(watch.Changes) != 1 || watch.Changes[0].Type != c.changeType || !watch.Changes[0].Key.Equals(key) {
+			t.Errorf("expected %s to see the key as %s but got %v", c.host, c.changeType, watch.Changes)
+		}
+	}
+}
+
+func TestMetricsRequireReadAuth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireReadAuth = true
+	cfg.Hosts = []ConfigHost{
+		{Name: "web-01", Token: "web-01-token", Users: []string{"deploy"}},
+		{Name: "bastion", Token: "bastion-token", Users: []string{"*"}},
+	}
+	defer setupTestServer(t, cfg)()
+
+	cases := []struct {
+		token string
+		code  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"web-01-token", http.StatusForbidden},
+		{"bastion-token", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		res := httptest.NewRecorder()
+		serverHandler().ServeHTTP(res, req)
+		if res.Code != c.code {
+			t.Errorf("expected metrics requested with the token '%s' to return %d but got %d", c.token, c.code, res.Code)
+		}
+	}
+}
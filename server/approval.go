@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+)
+
+// ConfigApproval requires keys for a user to be approved by a number of
+// distinct approvers, whose public keys are held in a separate keyring
+// or listed as SSH signing keys, before they become active.
+type ConfigApproval struct {
+	Required    int      `yaml:"required"`
+	KeyRing     string   `yaml:"keyring"`
+	SigningKeys []string `yaml:"signing_keys"`
+}
+
+func (a *ConfigApproval) GetKeyRing() (openpgp.EntityList, error) {
+	return openpgp.ReadArmoredKeyRing(strings.NewReader(a.KeyRing))
+}
+
+func (a *ConfigApproval) GetSigningKeys() ([]*crypto.SigningKey, error) {
+	return parseSigningKeys(a.SigningKeys)
+}
+
+// pendingKey is a key waiting to be approved, along with the identity of
+// the person who requested it so that they can't approve it with another
+// of their keys.
+type pendingKey struct {
+	crypto.PendingKey
+	requester string
+}
+
+// pendingKeys are held in memory regardless of the key store in use, so they
+// are lost when the server restarts.
+var pendingKeys = map[string]pendingKey{}
+var pendingKeysLock sync.Mutex
+
+// signerIdentity identifies the person who signed a request, so that somebody
+// holding several keys is only counted once. The email address from a PGP
+// key's user ID, or the identity of an SSH signing key, is used where there is
+// one, falling back to the ID of the key itself.
+func signerIdentity(ev *AuditEvent) string {
+	uid := ev.SignerUID
+	if i, j := strings.LastIndex(uid, "<"), strings.LastIndex(uid, ">"); i >= 0 && j > i {
+		uid = uid[i+1 : j]
+	}
+
+	uid = strings.ToLower(strings.TrimSpace(uid))
+	if uid == "" {
+		return ev.SignerKeyID
+	}
+
+	return uid
+}
+
+// AddPendingKey holds a key until it has been approved by the required
+// number of approvers. The ID of the pending key is derived from the
+// signed request which submitted it.
+func AddPendingKey(key *crypto.Key, payload []byte, ev *AuditEvent, required int) *crypto.PendingKey {
+	pendingKeysLock.Lock()
+	defer pendingKeysLock.Unlock()
+
+	h := sha256.Sum256(payload)
+	p := pendingKey{
+		PendingKey: crypto.PendingKey{
+			ID:          hex.EncodeToString(h[:16]),
+			Key:         *key,
+			Requested:   time.Now().UTC(),
+			RequestedBy: ev.SignerKeyID,
+			Required:    required,
+			Approvals:   []string{},
+		},
+		requester: signerIdentity(ev),
+	}
+
+	pendingKeys[p.ID] = p
+	return &p.PendingKey
+}
+
+// GetPendingKeys returns all of the keys which are waiting to be approved
+func GetPendingKeys() []crypto.PendingKey {
+	pendingKeysLock.Lock()
+	defer pendingKeysLock.Unlock()
+
+	removeExpiredPendingKeys()
+
+	keys := []crypto.PendingKey{}
+	for _, p := range pendingKeys {
+		keys = append(keys, p.PendingKey)
+	}
+
+	return keys
+}
+
+// GetPendingKey returns the pending key with the given ID, or nil if there
+// is no such key waiting to be approved.
+func GetPendingKey(id string) *crypto.PendingKey {
+	pendingKeysLock.Lock()
+	defer pendingKeysLock.Unlock()
+
+	removeExpiredPendingKeys()
+
+	p, ok := pendingKeys[id]
+	if !ok {
+		return nil
+	}
+
+	return &p.PendingKey
+}
+
+// ApprovePendingKey records an approval of the pending key by the person who
+// signed the approval. The approval which completes the key is not recorded,
+// instead the key should be activated and then removed with RemovePendingKey,
+// so that it remains pending if it cannot be activated.
+func ApprovePendingKey(id string, ev *AuditEvent) (*crypto.PendingKey, error) {
+	pendingKeysLock.Lock()
+	defer pendingKeysLock.Unlock()
+
+	removeExpiredPendingKeys()
+
+	p, ok := pendingKeys[id]
+	if !ok {
+		return nil, nil
+	}
+
+	approver := signerIdentity(ev)
+	if approver == p.requester || ev.SignerKeyID == p.RequestedBy {
+		return nil, fmt.Errorf("keys may not be approved by the person who requested them")
+	}
+
+	for _, a := range p.Approvals {
+		if a == approver {
+			return nil, fmt.Errorf("this key has already been approved by you")
+		}
+	}
+
+	approved := p.PendingKey
+	approved.Approvals = append(append([]string{}, p.Approvals...), approver)
+	if !approved.Approved() {
+		p.PendingKey = approved
+		pendingKeys[id] = p
+	}
+
+	return &approved, nil
+}
+
+// RemovePendingKey removes a key which has been approved and activated
+func RemovePendingKey(id string) {
+	pendingKeysLock.Lock()
+	defer pendingKeysLock.Unlock()
+
+	delete(pendingKeys, id)
+}
+
+func removeExpiredPendingKeys() {
+	for id, p := range pendingKeys {
+		if p.Key.Expires.Before(time.Now()) {
+			log.WithFields(log.Fields{
+				"id":   id,
+				"user": p.Key.User,
+			}).Info("Pending key expired before it was approved")
+			delete(pendingKeys, id)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SierraSoftworks/girder"
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConfigAudit controls where the audit log is written, if no file is
+// provided then audit events are not recorded.
+type ConfigAudit struct {
+	File string `yaml:"file"`
+}
+
+// AuditEvent is a single entry in the audit log, describing an action taken
+// against the server and its outcome.
+type AuditEvent struct {
+	Time         time.Time  `json:"time"`
+	Action       string     `json:"action"`
+	Outcome      string     `json:"outcome"`
+	Reason       string     `json:"reason,omitempty"`
+	User         string     `json:"user,omitempty"`
+	Fingerprint  string     `json:"fingerprint,omitempty"`
+	Fingerprints []string   `json:"fingerprints,omitempty"`
+	Expires      *time.Time `json:"expire,omitempty"`
+	SignerKeyID  string     `json:"signer_key_id,omitempty"`
+	SignerUID    string     `json:"signer_uid,omitempty"`
+	SourceIP     string     `json:"source_ip,omitempty"`
+	Host         string     `json:"host,omitempty"`
+}
+
+// NewAuditEvent prepares an audit event for an action being taken by the
+// client which made the request.
+func NewAuditEvent(c *girder.Context, action string) *AuditEvent {
+	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		ip = c.Request.RemoteAddr
+	}
+
+	return &AuditEvent{
+		Action:   action,
+		SourceIP: ip,
+	}
+}
+
+// SetKey records the details of the key that this event relates to
+func (e *AuditEvent) SetKey(k *crypto.Key) {
+	e.User = k.User
+	e.Fingerprint = k.Fingerprint()
+
+	if !k.Expires.IsZero() {
+		expires := k.Expires
+		e.Expires = &expires
+	}
+}
+
+// SetSigner records the identity of the PGP key which signed the request
+func (e *AuditEvent) SetSigner(signer *openpgp.Entity) {
+	if signer == nil || signer.PrimaryKey == nil {
+		return
+	}
+
+	e.SignerKeyID = signer.PrimaryKey.KeyIdString()
+
+	uids := []string{}
+	for name, id := range signer.Identities {
+		if id.SelfSignature != nil && id.SelfSignature.IsPrimaryId != nil && *id.SelfSignature.IsPrimaryId {
+			e.SignerUID = name
+			return
+		}
+
+		uids = append(uids, name)
+	}
+
+	if len(uids) > 0 {
+		sort.Strings(uids)
+		e.SignerUID = uids[0]
+	}
+}
+
+// SetSSHSigner records the identity of the SSH key which signed the request
+func (e *AuditEvent) SetSSHSigner(signer *crypto.SigningKey) {
+	if signer == nil {
+		return
+	}
+
+	e.SignerKeyID = ssh.FingerprintSHA256(signer.PublicKey)
+	e.SignerUID = signer.Identity
+}
+
+// Accept records that the action was successful
+func (e *AuditEvent) Accept() {
+	recordAccepted(e.Action)
+	e.Outcome = "accepted"
+	WriteAuditEvent(e)
+}
+
+// Pend records that the action is waiting for approval
+func (e *AuditEvent) Pend() {
+	recordPending(e.Action)
+	e.Outcome = "pending"
+	WriteAuditEvent(e)
+}
+
+// Reject records that the action was rejected for the given reason, returning
+// err so that it can be passed back to the client.
+func (e *AuditEvent) Reject(reason string, err error) error {
+	recordRejected(e.Action, reason)
+	e.Outcome = "rejected"
+	e.Reason = reason
+	WriteAuditEvent(e)
+	Notify(NewNotification("key.rejected", e, nil))
+	return err
+}
+
+var auditLog *os.File
+var auditLogLock sync.Mutex
+
+// OpenAuditLog starts appending audit events to the given file
+func OpenAuditLog(file string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+
+	if auditLog != nil {
+		auditLog.Close()
+	}
+
+	auditLog = f
+	return nil
+}
+
+// WriteAuditEvent appends the event to the audit log, if one is open
+func WriteAuditEvent(e *AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	auditLogLock.Lock()
+	defer auditLogLock.Unlock()
+
+	if auditLog == nil {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("Failed to encode audit event")
+		return
+	}
+
+	if _, err := auditLog.Write(append(data, '\n')); err != nil {
+		log.WithError(err).Error("Failed to write to the audit log")
+	}
+}
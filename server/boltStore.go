@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltKeysBucket = []byte("keys")
+
+// boltFingerprintsBucket indexes keys by their fingerprints, each entry is the
+// fingerprint index key followed by a null byte and the key's ID.
+var boltFingerprintsBucket = []byte("fingerprints")
+
+// boltRevokedBucket holds the revoked certificates, by their CA and serial
+var boltRevokedBucket = []byte("revoked")
+
+// BoltKeyStore persists keys to an embedded BoltDB database on disk so
+// that they survive restarts of the server.
+type BoltKeyStore struct {
+	db *bolt.DB
+}
+
+func NewBoltKeyStore(path string) (*BoltKeyStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		keys, err := tx.CreateBucketIfNotExists(boltKeysBucket)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(boltRevokedBucket); err != nil {
+			return err
+		}
+
+		if tx.Bucket(boltFingerprintsBucket) != nil {
+			return nil
+		}
+
+		// Databases created before the index existed need to have it built
+		index, err := tx.CreateBucket(boltFingerprintsBucket)
+		if err != nil {
+			return err
+		}
+
+		return keys.ForEach(func(id, data []byte) error {
+			var k crypto.Key
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+
+			return boltIndexKey(index, &k)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltKeyStore{db: db}, nil
+}
+
+func (s *BoltKeyStore) HasKey(key *crypto.Key) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltKeysBucket).Get(boltKeyID(key)) != nil
+		return nil
+	})
+
+	return found, err
+}
+
+func (s *BoltKeyStore) AddKey(key *crypto.Key) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltKeysBucket).Put(boltKeyID(key), data); err != nil {
+			return err
+		}
+
+		return boltIndexKey(tx.Bucket(boltFingerprintsBucket), key)
+	})
+}
+
+func (s *BoltKeyStore) GetKeysBy(pred KeyPredicate) ([]crypto.Key, error) {
+	results := []crypto.Key{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeysBucket).ForEach(func(id, data []byte) error {
+			var k crypto.Key
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+
+			if pred(&k) {
+				results = append(results, k)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *BoltKeyStore) GetKeysByFingerprint(user, fingerprint string) ([]crypto.Key, error) {
+	results := []crypto.Key{}
+	prefix := []byte(fingerprintIndexKey(user, fingerprint) + "\x00")
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(boltKeysBucket)
+		c := tx.Bucket(boltFingerprintsBucket).Cursor()
+		for entry, _ := c.Seek(prefix); entry != nil && bytes.HasPrefix(entry, prefix); entry, _ = c.Next() {
+			data := keys.Get(entry[len(prefix):])
+			if data == nil {
+				continue
+			}
+
+			var k crypto.Key
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+
+			results = append(results, k)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *BoltKeyStore) RemoveKeyBy(pred KeyPredicate) ([]crypto.Key, error) {
+	removed := []crypto.Key{}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltKeysBucket)
+		index := tx.Bucket(boltFingerprintsBucket)
+
+		ids := [][]byte{}
+		err := b.ForEach(func(id, data []byte) error {
+			var k crypto.Key
+			if err := json.Unmarshal(data, &k); err != nil {
+				return err
+			}
+
+			if pred(&k) {
+				ids = append(ids, append([]byte{}, id...))
+				removed = append(removed, k)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Keys cannot be deleted while iterating over the bucket
+		for i, id := range ids {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+
+			for _, f := range fingerprintIndexKeys(&removed[i]) {
+				if err := index.Delete(boltIndexEntry(f, id)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return removed, nil
+}
+
+func (s *BoltKeyStore) RevokeCertificate(cert *RevokedCertificate) error {
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltRevokedBucket)
+
+		expired := [][]byte{}
+		err := b.ForEach(func(id, data []byte) error {
+			var r RevokedCertificate
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+
+			if !r.Expires.After(time.Now()) {
+				expired = append(expired, append([]byte{}, id...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, id := range expired {
+			if err := b.Delete(id); err != nil {
+				return err
+			}
+		}
+
+		return b.Put([]byte(fmt.Sprintf("%s\x00%016x", cert.CA, cert.Serial)), data)
+	})
+}
+
+func (s *BoltKeyStore) GetRevokedCertificates() ([]RevokedCertificate, error) {
+	results := []RevokedCertificate{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRevokedBucket).ForEach(func(id, data []byte) error {
+			var r RevokedCertificate
+			if err := json.Unmarshal(data, &r); err != nil {
+				return err
+			}
+
+			if r.Expires.After(time.Now()) {
+				results = append(results, r)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (s *BoltKeyStore) Close() error {
+	return s.db.Close()
+}
+
+func boltKeyID(k *crypto.Key) []byte {
+	return []byte(fmt.Sprintf("%s\x00%s", k.User, k.PublicKey))
+}
+
+func boltIndexEntry(fingerprint string, id []byte) []byte {
+	return append([]byte(fingerprint+"\x00"), id...)
+}
+
+func boltIndexKey(index *bolt.Bucket, k *crypto.Key) error {
+	for _, f := range fingerprintIndexKeys(k) {
+		if err := index.Put(boltIndexEntry(f, boltKeyID(k)), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	"golang.org/x/crypto/ssh"
+)
+
+// ConfigCA configures the SSH certificate authority used to issue user
+// certificates. The private key may either be provided inline or loaded
+// from a file, if neither is set then certificates will not be issued.
+type ConfigCA struct {
+	PrivateKey string `yaml:"private_key"`
+	KeyFile    string `yaml:"key_file"`
+}
+
+// Enabled determines whether a certificate authority has been configured
+func (c *ConfigCA) Enabled() bool {
+	return c.PrivateKey != "" || c.KeyFile != ""
+}
+
+// Signer loads the certificate authority's private key
+func (c *ConfigCA) Signer() (ssh.Signer, error) {
+	data := []byte(c.PrivateKey)
+	if c.KeyFile != "" {
+		d, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		data = d
+	}
+
+	return ssh.ParsePrivateKey(data)
+}
+
+// certificateExtensions are the extensions granted to certificates by
+// default, matching those used by ssh-keygen.
+var certificateExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// IssueCertificate signs an OpenSSH user certificate for the key, valid for
+// its user until the key expires. The key's authorized_keys options are
+// translated into the equivalent critical options and extensions, if any of
+// them have no certificate equivalent then no certificate is issued.
+//
+// Certificates are accepted by every host which trusts the CA, so none are
+// issued for keys which have been scoped to specific hosts or groups.
+func IssueCertificate(ca ssh.Signer, key *crypto.Key) (*ssh.Certificate, error) {
+	if key.Scoped() {
+		return nil, fmt.Errorf("a certificate cannot be limited to specific hosts or groups")
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+
+	permissions, err := certificatePermissions(key.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%s:%s", key.User, key.Fingerprint()),
+		ValidPrincipals: []string{key.User},
+		// Allow for a small amount of clock skew between Inki and its hosts
+		ValidAfter:  uint64(time.Now().Add(-5 * time.Minute).Unix()),
+		ValidBefore: uint64(key.Expires.Unix()),
+		Permissions: *permissions,
+	}
+
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// CheckCertificateOptions ensures that a certificate can be issued for a key
+// with these options without granting more than its authorized_keys entry.
+func CheckCertificateOptions(options []string) error {
+	_, err := certificatePermissions(options)
+	return err
+}
+
+func certificatePermissions(options []string) (*ssh.Permissions, error) {
+	p := &ssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions:      map[string]string{},
+	}
+
+	restricted := false
+	for _, o := range options {
+		if crypto.OptionName(o) == "restrict" {
+			restricted = true
+		}
+	}
+
+	// Like in authorized_keys, restrict disables everything regardless of
+	// where it appears and other options may then re-enable features.
+	if !restricted {
+		for _, e := range certificateExtensions {
+			p.Extensions[e] = ""
+		}
+	}
+
+	for _, o := range options {
+		name := crypto.OptionName(o)
+		value, err := crypto.OptionValue(o)
+		if err != nil {
+			return nil, fmt.Errorf("the value of option '%s' %s", name, err)
+		}
+
+		switch name {
+		case "command":
+			p.CriticalOptions["force-command"] = value
+		case "from":
+			p.CriticalOptions["source-address"] = value
+		case "verify-required":
+			p.CriticalOptions["verify-required"] = ""
+		case "no-touch-required":
+			p.Extensions["no-touch-required"] = ""
+		case "restrict":
+		case "pty":
+			p.Extensions["permit-pty"] = ""
+		case "no-pty":
+			delete(p.Extensions, "permit-pty")
+		case "agent-forwarding":
+			p.Extensions["permit-agent-forwarding"] = ""
+		case "no-agent-forwarding":
+			delete(p.Extensions, "permit-agent-forwarding")
+		case "port-forwarding":
+			p.Extensions["permit-port-forwarding"] = ""
+		case "no-port-forwarding":
+			delete(p.Extensions, "permit-port-forwarding")
+		case "x11-forwarding":
+			p.Extensions["permit-X11-forwarding"] = ""
+		case "no-x11-forwarding":
+			delete(p.Extensions, "permit-X11-forwarding")
+		case "user-rc":
+			p.Extensions["permit-user-rc"] = ""
+		case "no-user-rc":
+			delete(p.Extensions, "permit-user-rc")
+		default:
+			// Options like permitopen and environment restrict what the key may
+			// be used for, leaving them out would grant more than the key's
+			// authorized_keys entry does.
+			return nil, fmt.Errorf("the option '%s' cannot be represented in a certificate", name)
+		}
+	}
+
+	return p, nil
+}
+
+const (
+	krlMagic              = 0x5353484b524c0a00
+	krlFormatVersion      = 1
+	krlSectionCertificate = 1
+	krlSectionCertSerials = 0x20
+)
+
+// MarshalKRL produces an OpenSSH key revocation list, which sshd can use as
+// its RevokedKeys file, revoking the certificates by their CA and serial.
+func MarshalKRL(revoked []RevokedCertificate, version uint64, comment string) ([]byte, error) {
+	serials := map[string][]uint64{}
+	cas := []string{}
+	for _, r := range revoked {
+		if _, ok := serials[r.CA]; !ok {
+			cas = append(cas, r.CA)
+		}
+
+		serials[r.CA] = append(serials[r.CA], r.Serial)
+	}
+	sort.Strings(cas)
+
+	b := bytes.NewBuffer([]byte{})
+	binary.Write(b, binary.BigEndian, uint64(krlMagic))
+	binary.Write(b, binary.BigEndian, uint32(krlFormatVersion))
+	binary.Write(b, binary.BigEndian, version)
+	binary.Write(b, binary.BigEndian, uint64(time.Now().Unix()))
+	binary.Write(b, binary.BigEndian, uint64(0))
+	b.Write(ssh.Marshal(struct{ Reserved, Comment string }{"", comment}))
+
+	for _, ca := range cas {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(ca))
+		if err != nil {
+			return nil, err
+		}
+
+		list := krlSerials(serials[ca])
+		sort.Sort(list)
+
+		data := bytes.NewBuffer([]byte{})
+		for _, serial := range list {
+			binary.Write(data, binary.BigEndian, serial)
+		}
+
+		section := ssh.Marshal(struct {
+			CAKey    []byte
+			Reserved string
+			Type     byte
+			Serials  []byte
+		}{pub.Marshal(), "", krlSectionCertSerials, data.Bytes()})
+
+		b.WriteByte(krlSectionCertificate)
+		b.Write(ssh.Marshal(struct{ Section []byte }{section}))
+	}
+
+	return b.Bytes(), nil
+}
+
+type krlSerials []uint64
+
+func (s krlSerials) Len() int           { return len(s) }
+func (s krlSerials) Less(i, j int) bool { return s[i] < s[j] }
+func (s krlSerials) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
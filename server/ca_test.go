@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertificatePermissions(t *testing.T) {
+	cases := []struct {
+		name       string
+		options    []string
+		critical   map[string]string
+		extensions []string
+	}{
+		{
+			name:       "defaults",
+			options:    []string{},
+			critical:   map[string]string{},
+			extensions: certificateExtensions,
+		},
+		{
+			name:     "restrict",
+			options:  []string{"restrict"},
+			critical: map[string]string{},
+		},
+		{
+			name:       "restrict with pty",
+			options:    []string{"pty", "restrict"},
+			critical:   map[string]string{},
+			extensions: []string{"permit-pty"},
+		},
+		{
+			name:       "disabled features",
+			options:    []string{"no-pty", "no-port-forwarding", "no-agent-forwarding"},
+			critical:   map[string]string{},
+			extensions: []string{"permit-X11-forwarding", "permit-user-rc"},
+		},
+		{
+			name:    "forced command and source",
+			options: []string{`command="/usr/bin/backup --now"`, `from="10.0.0.0/8"`},
+			critical: map[string]string{
+				"force-command":  "/usr/bin/backup --now",
+				"source-address": "10.0.0.0/8",
+			},
+			extensions: certificateExtensions,
+		},
+		{
+			name:    "backslashes in values",
+			options: []string{`command="echo \n"`, `from="10.0.0.0/8"`},
+			critical: map[string]string{
+				"force-command":  `echo \n`,
+				"source-address": "10.0.0.0/8",
+			},
+			extensions: certificateExtensions,
+		},
+		{
+			name:    "escaped quotes in values",
+			options: []string{`command="printf \"%s\\n\" hi"`},
+			critical: map[string]string{
+				"force-command": `printf "%s\\n" hi`,
+			},
+			extensions: certificateExtensions,
+		},
+	}
+
+	for _, c := range cases {
+		p, err := certificatePermissions(c.options)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(p.CriticalOptions, c.critical) {
+			t.Errorf("%s: expected critical options %v but got %v", c.name, c.critical, p.CriticalOptions)
+		}
+
+		extensions := map[string]string{}
+		for _, e := range c.extensions {
+			extensions[e] = ""
+		}
+
+		if !reflect.DeepEqual(p.Extensions, extensions) {
+			t.Errorf("%s: expected extensions %v but got %v", c.name, extensions, p.Extensions)
+		}
+	}
+}
+
+func TestCertificatePermissionsRefusesRestrictions(t *testing.T) {
+	options := []string{
+		`permitopen="db:5432"`,
+		`permitlisten="localhost:8080"`,
+		`environment="FOO=bar"`,
+		`tunnel="0"`,
+		`expiry-time="20300101"`,
+	}
+
+	for _, o := range options {
+		if p, err := certificatePermissions([]string{"no-pty", o}); err == nil {
+			t.Errorf("expected '%s' to be refused but got %v", o, p)
+		}
+
+		if err := CheckCertificateOptions([]string{o}); err == nil {
+			t.Errorf("expected '%s' to be refused by CheckCertificateOptions", o)
+		}
+	}
+}
+
+func TestIssueCertificate(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := newTestKey(t, "alice")
+	key.Options = []string{"no-pty"}
+
+	cert, err := IssueCertificate(ca, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cert.ValidPrincipals, []string{"alice"}) {
+		t.Errorf("expected the certificate to be valid for alice but got %v", cert.ValidPrincipals)
+	}
+
+	if cert.ValidBefore != uint64(key.Expires.Unix()) {
+		t.Errorf("expected the certificate to expire with the key")
+	}
+
+	if _, ok := cert.Extensions["permit-pty"]; ok {
+		t.Error("expected permit-pty to be removed by no-pty")
+	}
+
+	key.Options = []string{`permitopen="db:5432"`}
+	if _, err := IssueCertificate(ca, key); err == nil {
+		t.Error("expected no certificate to be issued for a key restricted with permitopen")
+	}
+
+	key.Options = nil
+	key.Groups = []string{"web"}
+	if _, err := IssueCertificate(ca, key); err == nil {
+		t.Error("expected no certificate to be issued for a key scoped to a group")
+	}
+}
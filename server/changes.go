@@ -0,0 +1,92 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+// KeyChange records a key being added to, or removed from, the key store.
+// Keys which are replaced, for example when their expiry is extended, are
+// recorded as being added again.
+type KeyChange struct {
+	Revision uint64     `json:"revision"`
+	Type     string     `json:"type"`
+	Time     time.Time  `json:"time"`
+	Key      crypto.Key `json:"key"`
+}
+
+// maxChanges is the number of changes which are retained for clients which
+// are catching up, clients which fall further behind must start again.
+const maxChanges = 1024
+
+type changeLog struct {
+	sync.Mutex
+	revision uint64
+	entries  []KeyChange
+	notify   chan struct{}
+}
+
+// The revision starts at the current time so that it continues to increase
+// across restarts of the server, which keeps clients from mistaking a new
+// revision for one they have already seen. Microseconds are used so that it
+// remains safe to handle as a JavaScript number.
+var changes = &changeLog{
+	revision: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+	notify:   make(chan struct{}),
+}
+
+func recordChange(changeType string, key *crypto.Key) {
+	changes.Lock()
+	defer changes.Unlock()
+
+	changes.revision++
+	changes.entries = append(changes.entries, KeyChange{
+		Revision: changes.revision,
+		Type:     changeType,
+		Time:     time.Now().UTC(),
+		Key:      *key,
+	})
+
+	if len(changes.entries) > maxChanges {
+		changes.entries = changes.entries[len(changes.entries)-maxChanges:]
+	}
+
+	close(changes.notify)
+	changes.notify = make(chan struct{})
+}
+
+// CurrentRevision returns the revision of the most recent change to the store
+func CurrentRevision() uint64 {
+	changes.Lock()
+	defer changes.Unlock()
+
+	return changes.revision
+}
+
+// GetChangesSince returns the changes which were made after the given revision
+// along with a channel which is closed when the next change is made. If some of
+// the changes are no longer available, or the revision is unknown, ok is false
+// and the client should fetch the full list of keys again.
+func GetChangesSince(revision uint64) (entries []KeyChange, ok bool, next <-chan struct{}) {
+	changes.Lock()
+	defer changes.Unlock()
+
+	oldest := changes.revision
+	if len(changes.entries) > 0 {
+		oldest = changes.entries[0].Revision - 1
+	}
+
+	if revision < oldest || revision > changes.revision {
+		return nil, false, changes.notify
+	}
+
+	for _, c := range changes.entries {
+		if c.Revision > revision {
+			entries = append(entries, c)
+		}
+	}
+
+	return entries, true, changes.notify
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"os"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/urfave/cli"
 )
@@ -26,6 +28,26 @@ var Command = cli.Command{
 			EnvVar: "PORT",
 			Value:  3000,
 		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Usage:  "The TLS certificate which the server should use to serve HTTPS",
+			EnvVar: "INKI_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Usage:  "The private key for the server's TLS certificate",
+			EnvVar: "INKI_TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-client-ca",
+			Usage:  "The CA bundle used to verify client certificates",
+			EnvVar: "INKI_TLS_CLIENT_CA",
+		},
+		cli.BoolFlag{
+			Name:   "watch-config",
+			Usage:  "Reload the configuration file automatically whenever it changes",
+			EnvVar: "INKI_WATCH_CONFIG",
+		},
 	},
 	Before: func(c *cli.Context) error {
 		if c.IsSet("config") {
@@ -38,26 +60,119 @@ var Command = cli.Command{
 			log.Warn("No configuration file provided, using empty defaults")
 		}
 
-		return nil
+		if file := GetConfig().Audit.File; file != "" {
+			if err := OpenAuditLog(file); err != nil {
+				log.WithError(err).WithField("file", file).Error("Failed to open audit log")
+				return err
+			}
+
+			log.WithField("file", file).Info("Opened audit log")
+		}
+
+		if len(GetConfig().Requests.Audience) == 0 {
+			hostname, _ := os.Hostname()
+			log.WithField("hostname", hostname).Warn("No audience is configured for signed requests, only those sent to this server's hostname will be accepted")
+		}
+
+		ks, err := GetConfig().Store.Open()
+		if err != nil {
+			log.WithError(err).WithField("type", GetConfig().Store.Type).Error("Failed to open key store")
+			return err
+		}
+
+		log.WithField("type", GetConfig().Store.Type).Info("Opened key store")
+		return SetStore(ks)
 	},
 	Action: func(c *cli.Context) error {
-		port := c.Int("port")
+		port := GetConfig().Port
+		if c.IsSet("port") || port == 0 {
+			port = c.Int("port")
+		}
+
 		log.WithField("port", port).Info("Starting server")
 
-		mux := http.NewServeMux()
-		mux.Handle("/api/", http.StripPrefix("/api", Router()))
-		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(404)
-			w.Write([]byte(`{"code": 404, "error": "Not Found", "message": "The method you attempted to make use of could not be found on our system."}`))
-		})
-
-		return http.ListenAndServe(fmt.Sprintf("0.0.0.0:%d", port), cors.New(cors.Options{
-			AllowCredentials: true,
-			AllowedOrigins:   []string{"*"},
-			AllowedHeaders:   []string{"Authorization", "Content-Type"},
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-			Debug:            false,
-		}).Handler(mux))
+		if c.IsSet("config") {
+			ReloadConfigOnSignal(c.String("config"))
+
+			if c.Bool("watch-config") {
+				watcher, err := WatchConfig(c.String("config"))
+				if err != nil {
+					log.WithError(err).Error("Failed to watch configuration file for changes")
+					return err
+				}
+
+				defer watcher.Close()
+			}
+		}
+
+		reaper := GetConfig().Reaper
+		if reaper.Interval > 0 {
+			log.WithFields(log.Fields{
+				"interval": reaper.Interval,
+				"grace":    reaper.Grace,
+			}).Info("Starting expired key reaper")
+			defer close(StartReaper(reaper.Interval, reaper.Grace))
+		}
+
+		defer close(StartNotifier())
+
+		server := &http.Server{
+			Addr: fmt.Sprintf("0.0.0.0:%d", port),
+			Handler: cors.New(cors.Options{
+				AllowCredentials: true,
+				AllowedOrigins:   []string{"*"},
+				AllowedHeaders:   []string{"Authorization", "Content-Type"},
+				AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+				Debug:            false,
+			}).Handler(serverHandler()),
+		}
+
+		tlsConfig := GetConfig().TLS
+		if c.IsSet("tls-cert") {
+			tlsConfig.Cert = c.String("tls-cert")
+		}
+
+		if c.IsSet("tls-key") {
+			tlsConfig.Key = c.String("tls-key")
+		}
+
+		if c.IsSet("tls-client-ca") {
+			tlsConfig.ClientCA = c.String("tls-client-ca")
+		}
+
+		if !tlsConfig.Enabled() {
+			return server.ListenAndServe()
+		}
+
+		cfg, err := tlsConfig.TLSConfig()
+		if err != nil {
+			log.WithError(err).Error("Failed to load TLS configuration")
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"cert":      tlsConfig.Cert,
+			"client_ca": tlsConfig.ClientCA,
+		}).Info("Serving HTTPS")
+
+		server.TLSConfig = cfg
+		return server.ListenAndServeTLS("", "")
 	},
 }
+
+// serverHandler serves the API and metrics. It is a mux.Router rather than an
+// http.ServeMux because the latter redirects any path containing "//", which
+// SHA256 fingerprints may.
+func serverHandler() http.Handler {
+	routes := mux.NewRouter()
+	routes.SkipClean(true)
+	routes.PathPrefix("/api/").Handler(http.StripPrefix("/api", Router()))
+	routes.Handle("/metrics", metricsHandler())
+	routes.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		w.Write([]byte(`{"code": 404, "error": "Not Found", "message": "The method you attempted to make use of could not be found on our system."}`))
+	})
+
+	return routes
+}
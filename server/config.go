@@ -1,18 +1,38 @@
 package server
 
 import (
+	"fmt"
 	"io/ioutil"
+	"sync"
 
 	"strings"
+	"time"
 
+	"github.com/SierraSoftworks/inki/crypto"
 	log "github.com/Sirupsen/logrus"
 	"golang.org/x/crypto/openpgp"
 	yaml "gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Port  int          `yaml:"port"`
-	Users []ConfigUser `yaml:"users"`
+	Port     int            `yaml:"port"`
+	TLS      ConfigTLS      `yaml:"tls"`
+	Store    ConfigStore    `yaml:"store"`
+	Reaper   ConfigReaper   `yaml:"reaper"`
+	Requests ConfigRequests `yaml:"requests"`
+	CA       ConfigCA       `yaml:"ca"`
+	Audit    ConfigAudit    `yaml:"audit"`
+	Users    []ConfigUser   `yaml:"users"`
+
+	// PolicyHook is consulted before any key is accepted
+	PolicyHook ConfigPolicyHook `yaml:"policy_hook"`
+
+	Notifications []ConfigNotification `yaml:"notifications"`
+
+	// RequireReadAuth requires clients to authenticate as one of the
+	// configured hosts before they can read keys.
+	RequireReadAuth bool         `yaml:"require_read_auth"`
+	Hosts           []ConfigHost `yaml:"hosts"`
 }
 
 func (c *Config) GetUser(name string) *ConfigUser {
@@ -26,8 +46,15 @@ func (c *Config) GetUser(name string) *ConfigUser {
 }
 
 type ConfigUser struct {
-	Name    string `yaml:"name"`
-	KeyRing string `yaml:"keyring"`
+	Name     string         `yaml:"name"`
+	KeyRing  string         `yaml:"keyring"`
+	Policy   ConfigPolicy   `yaml:"policy"`
+	Options  []string       `yaml:"options"`
+	Approval ConfigApproval `yaml:"approval"`
+
+	// SigningKeys are SSH public keys, in allowed_signers or authorized_keys
+	// format, which may sign requests for this user instead of a PGP key.
+	SigningKeys []string `yaml:"signing_keys"`
 }
 
 func (u *ConfigUser) GetKeyRing() (openpgp.KeyRing, error) {
@@ -41,29 +68,242 @@ func (u *ConfigUser) GetKeyRing() (openpgp.KeyRing, error) {
 	return el, nil
 }
 
-var config Config
+func (u *ConfigUser) GetSigningKeys() ([]*crypto.SigningKey, error) {
+	return parseSigningKeys(u.SigningKeys)
+}
+
+func parseSigningKeys(lines []string) ([]*crypto.SigningKey, error) {
+	keys := []*crypto.SigningKey{}
+	for i, line := range lines {
+		k, err := crypto.ParseSigningKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %d is not valid: %s", i+1, err)
+		}
+
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+type ConfigStore struct {
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+// Open creates the key store described by this configuration entry
+func (s *ConfigStore) Open() (KeyStore, error) {
+	switch strings.ToLower(s.Type) {
+	case "", "memory":
+		return NewMemoryKeyStore(), nil
+	case "bolt", "boltdb":
+		if s.Path == "" {
+			return nil, fmt.Errorf("the bolt key store requires a path to be configured")
+		}
+
+		return NewBoltKeyStore(s.Path)
+	default:
+		return nil, fmt.Errorf("unknown key store type '%s'", s.Type)
+	}
+}
+
+// ConfigReaper controls how often expired keys are removed from the key
+// store, and how long they are kept around after they expire. Setting the
+// interval to zero disables the reaper.
+type ConfigReaper struct {
+	Interval time.Duration `yaml:"interval"`
+	Grace    time.Duration `yaml:"grace"`
+}
+
+var config *Config
+var configLock sync.RWMutex
 
 func init() {
-	config = Config{
-		Port:  3000,
+	config = DefaultConfig()
+}
+
+// DefaultConfig returns the configuration used by the server when no
+// configuration file has been provided.
+func DefaultConfig() *Config {
+	return &Config{
+		Port: 3000,
+		Store: ConfigStore{
+			Type: "memory",
+		},
+		Reaper: ConfigReaper{
+			Interval: time.Minute,
+			Grace:    time.Hour,
+		},
+		Requests: ConfigRequests{
+			ClockSkew: 5 * time.Minute,
+		},
 		Users: []ConfigUser{},
+		Hosts: []ConfigHost{},
 	}
 }
 
+// GetConfig returns the configuration currently in use by the server. The
+// returned configuration must not be modified, as it may be in use by other
+// requests, and callers should avoid holding on to it for longer than they
+// need to so that they observe reloads.
 func GetConfig() *Config {
-	return &config
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	return config
+}
+
+// SetConfig replaces the configuration used by the server
+func SetConfig(c *Config) {
+	configLock.Lock()
+	old := config
+	config = c
+	configLock.Unlock()
+
+	logConfigChanges(old, c)
 }
 
+// LoadConfig reads and validates the configuration file, replacing the current
+// configuration with it if it is valid. If it is not, the current configuration
+// remains in use.
 func LoadConfig(file string) error {
-	fileData, err := ioutil.ReadFile(file)
+	c, err := ReadConfig(file)
 	if err != nil {
 		return err
 	}
 
-	err = yaml.Unmarshal(fileData, &config)
+	SetConfig(c)
+	return nil
+}
+
+// ReadConfig reads and validates the configuration file without applying it
+func ReadConfig(file string) (*Config, error) {
+	fileData, err := ioutil.ReadFile(file)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	c := DefaultConfig()
+	err = yaml.Unmarshal(fileData, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Validate ensures that every part of the configuration can be used,
+// including parsing the keyring of each user.
+func (c *Config) Validate() error {
+	if c.CA.Enabled() {
+		if _, err := c.CA.Signer(); err != nil {
+			return fmt.Errorf("the certificate authority's private key is not valid: %s", err)
+		}
+	}
+
+	if c.PolicyHook.Enabled() {
+		if err := c.PolicyHook.Validate(); err != nil {
+			return fmt.Errorf("the policy webhook is not valid: %s", err)
+		}
+	}
+
+	for i, n := range c.Notifications {
+		if err := n.Validate(); err != nil {
+			return fmt.Errorf("notification %d is not valid: %s", i+1, err)
+		}
+	}
+
+	hosts := map[string]bool{}
+	tokens := map[string]bool{}
+	for _, h := range c.Hosts {
+		if h.Name == "" {
+			return fmt.Errorf("a host is missing its name")
+		}
+
+		if hosts[h.Name] {
+			return fmt.Errorf("the host '%s' has been configured more than once", h.Name)
+		}
+		hosts[h.Name] = true
+
+		if h.Token != "" {
+			if tokens[h.Token] {
+				return fmt.Errorf("the host '%s' uses the same token as another host", h.Name)
+			}
+			tokens[h.Token] = true
+		}
+	}
+
+	names := map[string]bool{}
+	for _, u := range c.Users {
+		if u.Name == "" {
+			return fmt.Errorf("a user is missing its name")
+		}
+
+		if names[u.Name] {
+			return fmt.Errorf("the user '%s' has been configured more than once", u.Name)
+		}
+		names[u.Name] = true
+
+		// Users who only sign requests with SSH keys don't need a keyring
+		if u.KeyRing != "" || len(u.SigningKeys) == 0 {
+			if _, err := u.GetKeyRing(); err != nil {
+				return fmt.Errorf("the keyring for user '%s' is not valid: %s", u.Name, err)
+			}
+		}
+
+		if _, err := u.GetSigningKeys(); err != nil {
+			return fmt.Errorf("the signing keys for user '%s' are not valid: %s", u.Name, err)
+		}
+
+		if u.Approval.Required > 0 {
+			approvers := 0
+			if u.Approval.KeyRing != "" || len(u.Approval.SigningKeys) == 0 {
+				kr, err := u.Approval.GetKeyRing()
+				if err != nil {
+					return fmt.Errorf("the approver keyring for user '%s' is not valid: %s", u.Name, err)
+				}
+				approvers += len(kr)
+			}
+
+			sk, err := u.Approval.GetSigningKeys()
+			if err != nil {
+				return fmt.Errorf("the approver signing keys for user '%s' are not valid: %s", u.Name, err)
+			}
+			approvers += len(sk)
+
+			if approvers < u.Approval.Required {
+				return fmt.Errorf("user '%s' requires %d approvals but only has %d approvers", u.Name, u.Approval.Required, approvers)
+			}
+		}
+
+		if err := u.Policy.Validate(); err != nil {
+			return fmt.Errorf("the policy for user '%s' is not valid: %s", u.Name, err)
+		}
+
+		for _, o := range u.Options {
+			if err := crypto.ValidateOption(o); err != nil {
+				return fmt.Errorf("the options for user '%s' are not valid: %s", u.Name, err)
+			}
+		}
 	}
 
 	return nil
 }
+
+func logConfigChanges(prev, next *Config) {
+	for _, u := range next.Users {
+		if prev.GetUser(u.Name) == nil {
+			log.WithField("user", u.Name).Info("Added user to configuration")
+		}
+	}
+
+	for _, u := range prev.Users {
+		if next.GetUser(u.Name) == nil {
+			log.WithField("user", u.Name).Info("Removed user from configuration")
+		}
+	}
+}
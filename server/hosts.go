@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/SierraSoftworks/girder"
+	"github.com/SierraSoftworks/girder/errors"
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigHost describes a host which is permitted to read keys from the
+// server. Hosts authenticate using their bearer token, or with a client
+// certificate whose common name matches their name, and may only read the
+// keys of the users listed for them ("*" permits every user).
+type ConfigHost struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+}
+
+// CanRead determines whether the host may read the keys for a user. A nil
+// host represents an unauthenticated client when read authentication is
+// disabled, and may read every user's keys.
+func (h *ConfigHost) CanRead(user string) bool {
+	if h == nil {
+		return true
+	}
+
+	for _, u := range h.Users {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetHostByToken finds the host which uses the provided bearer token
+func (c *Config) GetHostByToken(token string) *ConfigHost {
+	if token == "" {
+		return nil
+	}
+
+	for _, h := range c.Hosts {
+		if h.Token != "" && subtle.ConstantTimeCompare([]byte(h.Token), []byte(token)) == 1 {
+			return &h
+		}
+	}
+
+	return nil
+}
+
+// GetHost finds the host with the provided name
+func (c *Config) GetHost(name string) *ConfigHost {
+	for _, h := range c.Hosts {
+		if h.Name == name {
+			return &h
+		}
+	}
+
+	return nil
+}
+
+// authenticateHost identifies the host which made a read request. If read
+// authentication is disabled then requests without credentials are permitted
+// and a nil host is returned.
+func authenticateHost(r *http.Request) (*ConfigHost, error) {
+	cfg := GetConfig()
+
+	hasClientCert := r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+	if cfg.TLS.RequireClientCert && !hasClientCert {
+		log.WithField("source", r.RemoteAddr).Warn("Client did not present a valid certificate")
+		return nil, errors.Unauthorized()
+	}
+
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		host := cfg.GetHostByToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+		if host == nil {
+			log.WithField("source", r.RemoteAddr).Warn("Client presented an unknown bearer token")
+			return nil, errors.Unauthorized()
+		}
+
+		return host, nil
+	}
+
+	if hasClientCert {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if host := cfg.GetHost(name); host != nil {
+			return host, nil
+		}
+	}
+
+	if cfg.RequireReadAuth {
+		log.WithField("source", r.RemoteAddr).Warn("Client did not identify itself as a known host")
+		return nil, errors.Unauthorized()
+	}
+
+	return nil, nil
+}
+
+// requestingHost determines the identity of the host which keys are being
+// requested for, so that only keys scoped to it are returned. Authenticated
+// hosts are always identified by their configuration, otherwise the host may
+// identify itself using the host query parameter. Since anybody could claim
+// to be a configured host, those must authenticate to be identified. If the
+// host cannot be identified, only keys which are not scoped will apply to it.
+func requestingHost(r *http.Request, host *ConfigHost) (string, []string) {
+	if host != nil {
+		return host.Name, host.Groups
+	}
+
+	name := r.URL.Query().Get("host")
+	if name == "" {
+		return "", nil
+	}
+
+	if GetConfig().GetHost(name) != nil {
+		log.WithFields(log.Fields{
+			"host":   name,
+			"source": r.RemoteAddr,
+		}).Warn("Client claimed to be a configured host without authenticating as it")
+		return "", nil
+	}
+
+	return name, nil
+}
+
+// authorizeRead ensures that the client is permitted to read the keys for
+// the given user, returning the host it authenticated as.
+func authorizeRead(c *girder.Context, user string) (*ConfigHost, error) {
+	host, err := authenticateHost(c.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	if !host.CanRead(user) {
+		log.WithFields(log.Fields{
+			"host": host.Name,
+			"user": user,
+		}).Warn("Host is not permitted to read this user's keys")
+		return nil, errors.NotAllowed()
+	}
+
+	return host, nil
+}
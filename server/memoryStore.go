@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+// MemoryKeyStore holds keys in memory, meaning that they will be lost
+// whenever the server is restarted.
+type MemoryKeyStore struct {
+	keys []crypto.Key
+	lock sync.Mutex
+
+	// fingerprints maps each of a key's fingerprints to its position in keys
+	fingerprints map[string][]int
+
+	revoked []RevokedCertificate
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:         []crypto.Key{},
+		fingerprints: map[string][]int{},
+		revoked:      []RevokedCertificate{},
+	}
+}
+
+func (s *MemoryKeyStore) HasKey(key *crypto.Key) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, k := range s.keys {
+		if k.Equals(key) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryKeyStore) AddKey(key *crypto.Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, k := range s.keys {
+		if k.Equals(key) {
+			// Update the existing entry (and its expiry time)
+			s.keys[i] = *key
+			return nil
+		}
+	}
+
+	s.keys = append(s.keys, *key)
+	s.index(len(s.keys) - 1)
+	return nil
+}
+
+func (s *MemoryKeyStore) GetKeysBy(pred KeyPredicate) ([]crypto.Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	results := []crypto.Key{}
+	for _, k := range s.keys {
+		if pred(&k) {
+			results = append(results, k)
+		}
+	}
+
+	return results, nil
+}
+
+func (s *MemoryKeyStore) GetKeysByFingerprint(user, fingerprint string) ([]crypto.Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	results := []crypto.Key{}
+	for _, i := range s.fingerprints[fingerprintIndexKey(user, fingerprint)] {
+		results = append(results, s.keys[i])
+	}
+
+	return results, nil
+}
+
+func (s *MemoryKeyStore) RemoveKeyBy(pred KeyPredicate) ([]crypto.Key, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := []crypto.Key{}
+	removed := []crypto.Key{}
+	for _, k := range s.keys {
+		if pred(&k) {
+			removed = append(removed, k)
+		} else {
+			kept = append(kept, k)
+		}
+	}
+
+	s.keys = kept
+
+	// Removing keys changes the positions of those which remain
+	if len(removed) > 0 {
+		s.fingerprints = map[string][]int{}
+		for i := range s.keys {
+			s.index(i)
+		}
+	}
+
+	return removed, nil
+}
+
+func (s *MemoryKeyStore) index(i int) {
+	for _, f := range fingerprintIndexKeys(&s.keys[i]) {
+		s.fingerprints[f] = append(s.fingerprints[f], i)
+	}
+}
+
+func (s *MemoryKeyStore) RevokeCertificate(cert *RevokedCertificate) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	revoked := []RevokedCertificate{*cert}
+	for _, r := range s.revoked {
+		if r.Expires.After(time.Now()) && (r.CA != cert.CA || r.Serial != cert.Serial) {
+			revoked = append(revoked, r)
+		}
+	}
+
+	s.revoked = revoked
+	return nil
+}
+
+func (s *MemoryKeyStore) GetRevokedCertificates() ([]RevokedCertificate, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	results := []RevokedCertificate{}
+	for _, r := range s.revoked {
+		if r.Expires.After(time.Now()) {
+			results = append(results, r)
+		}
+	}
+
+	return results, nil
+}
+
+func (s *MemoryKeyStore) Close() error {
+	return nil
+}
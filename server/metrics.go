@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var keyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "inki",
+	Name:      "key_requests_total",
+	Help:      "The number of signed key requests received, by action, outcome and rejection reason.",
+}, []string{"action", "outcome", "reason"})
+
+var notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "inki",
+	Name:      "notifications_total",
+	Help:      "The number of event notifications processed, by outcome.",
+}, []string{"outcome"})
+
+var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "inki",
+	Name:      "http_request_duration_seconds",
+	Help:      "The time taken to respond to API requests, by route.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "code"})
+
+var keysDesc = prometheus.NewDesc(
+	"inki_keys",
+	"The number of keys held in the key store, by user and state.",
+	[]string{"user", "state"},
+	nil,
+)
+
+func init() {
+	prometheus.MustRegister(keyRequests, notificationsSent, requestDuration, &keysCollector{})
+
+	Router().Use(instrumentRoute)
+}
+
+// recordAccepted counts a signed request which was accepted
+func recordAccepted(action string) {
+	keyRequests.WithLabelValues(action, "accepted", "").Inc()
+}
+
+// recordPending counts a signed request which is waiting for approval
+func recordPending(action string) {
+	keyRequests.WithLabelValues(action, "pending", "").Inc()
+}
+
+// recordRejected counts a signed request which was rejected, reason should be
+// a short machine readable description like "bad_signature".
+func recordRejected(action, reason string) {
+	keyRequests.WithLabelValues(action, "rejected", reason).Inc()
+}
+
+// recordNotification counts a notification which was sent, failed or dropped
+func recordNotification(outcome string) {
+	notificationsSent.WithLabelValues(outcome).Inc()
+}
+
+// keysCollector reports the number of active and expired keys for each user
+// from the key store whenever metrics are collected.
+type keysCollector struct{}
+
+func (c *keysCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+}
+
+func (c *keysCollector) Collect(ch chan<- prometheus.Metric) {
+	keys, err := GetAllKeys()
+	if err != nil {
+		log.WithError(err).Error("Failed to retrieve keys from the key store")
+		return
+	}
+
+	active := map[string]int{}
+	expired := map[string]int{}
+	for _, k := range keys {
+		if k.Expires.After(time.Now()) {
+			active[k.User]++
+		} else {
+			expired[k.User]++
+		}
+	}
+
+	for user, n := range active {
+		ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(n), user, "active")
+	}
+
+	for user, n := range expired {
+		ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(n), user, "expired")
+	}
+}
+
+// metricsHandler serves the metrics to clients which may read every user's
+// keys, since they are labelled with the names of users. When read
+// authentication is disabled they are available to everybody.
+func metricsHandler() http.Handler {
+	metrics := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, err := authenticateHost(r)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code": 401, "error": "Unauthorized", "message": "You must authenticate to view the server's metrics."}`))
+			return
+		}
+
+		if !host.CanRead("*") {
+			log.WithField("host", host.Name).Warn("Host is not permitted to read every user's keys, so may not view the server's metrics")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"code": 403, "error": "Forbidden", "message": "You are not permitted to view the server's metrics."}`))
+			return
+		}
+
+		metrics.ServeHTTP(w, r)
+	})
+}
+
+// instrumentRoute records how long each named route takes to respond
+func instrumentRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil && cr.GetName() != "" {
+			route = cr.GetName()
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+
+		requestDuration.
+			WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush allows streaming responses to be sent through the instrumentation
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigNotification sends events to an HTTP endpoint, like a Slack incoming
+// webhook. If no events or users are listed then all of them are sent.
+type ConfigNotification struct {
+	URL    string   `yaml:"url"`
+	Events []string `yaml:"events"`
+	Users  []string `yaml:"users"`
+
+	// Template is a text/template used to render the request body, by default
+	// the notification is sent as JSON.
+	Template    string `yaml:"template"`
+	ContentType string `yaml:"content_type"`
+
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// Notification describes something which happened to a key
+type Notification struct {
+	Event       string     `json:"event"`
+	Action      string     `json:"action,omitempty"`
+	Time        time.Time  `json:"time"`
+	User        string     `json:"user,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Expires     *time.Time `json:"expire,omitempty"`
+	Options     []string   `json:"options,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	Signer      string     `json:"signer,omitempty"`
+	SourceIP    string     `json:"source_ip,omitempty"`
+}
+
+// NewNotification prepares a notification from the audit event for an action,
+// the key is optional and provides the options which were granted.
+func NewNotification(event string, e *AuditEvent, key *crypto.Key) *Notification {
+	n := &Notification{
+		Event:       event,
+		Action:      e.Action,
+		Time:        e.Time,
+		User:        e.User,
+		Fingerprint: e.Fingerprint,
+		Expires:     e.Expires,
+		Reason:      e.Reason,
+		Signer:      e.SignerUID,
+		SourceIP:    e.SourceIP,
+	}
+
+	if n.Time.IsZero() {
+		n.Time = time.Now().UTC()
+	}
+
+	if n.Signer == "" {
+		n.Signer = e.SignerKeyID
+	}
+
+	if key != nil {
+		n.Options = key.Options
+	}
+
+	return n
+}
+
+var notificationFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join": strings.Join,
+}
+
+// Validate ensures that the notification's template can be parsed
+func (n *ConfigNotification) Validate() error {
+	if n.URL == "" {
+		return fmt.Errorf("no URL was provided")
+	}
+
+	if n.Template != "" {
+		if _, err := template.New("notification").Funcs(notificationFuncs).Parse(n.Template); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches determines whether the notification should be sent to this endpoint
+func (n *ConfigNotification) Matches(notification *Notification) bool {
+	if len(n.Events) > 0 && !matchesAny(n.Events, notification.Event) {
+		return false
+	}
+
+	if len(n.Users) > 0 && !matchesAny(n.Users, notification.User) {
+		return false
+	}
+
+	return true
+}
+
+// Render produces the request body and content type for the notification
+func (n *ConfigNotification) Render(notification *Notification) ([]byte, string, error) {
+	if n.Template == "" {
+		data, err := json.Marshal(notification)
+		return data, "application/json", err
+	}
+
+	t, err := template.New("notification").Funcs(notificationFuncs).Parse(n.Template)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := bytes.NewBuffer([]byte{})
+	if err := t.Execute(b, notification); err != nil {
+		return nil, "", err
+	}
+
+	contentType := n.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	return b.Bytes(), contentType, nil
+}
+
+// Send delivers the notification, retrying with an exponential backoff if the
+// endpoint cannot be reached or responds with an error.
+func (n *ConfigNotification) Send(notification *Notification) error {
+	body, contentType, err := n.Render(notification)
+	if err != nil {
+		return err
+	}
+
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	backoff := n.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	client := &http.Client{Timeout: timeout}
+	for attempt := 0; ; attempt++ {
+		err = n.post(client, body, contentType)
+		if err == nil || attempt >= n.Retries {
+			return err
+		}
+
+		log.WithError(err).WithFields(log.Fields{
+			"url":     n.URL,
+			"event":   notification.Event,
+			"attempt": attempt + 1,
+		}).Debug("Failed to send notification, retrying")
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (n *ConfigNotification) post(client *http.Client, body []byte, contentType string) error {
+	res, err := client.Post(n.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("the endpoint responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// notificationQueues holds a bounded queue for each endpoint, with its own
+// worker, so that a slow or unreachable endpoint can't hold up requests or
+// delivery to the other endpoints. Once an endpoint's queue is full its new
+// notifications are dropped.
+var notificationQueues = map[string]chan queuedNotification{}
+var notificationQueuesLock sync.Mutex
+var notifierStop chan struct{}
+
+type queuedNotification struct {
+	target       ConfigNotification
+	notification *Notification
+}
+
+// Notify queues the notification for delivery to every matching endpoint
+func Notify(notification *Notification) {
+	for _, target := range GetConfig().Notifications {
+		if !target.Matches(notification) {
+			continue
+		}
+
+		select {
+		case notificationQueue(target.URL) <- queuedNotification{target, notification}:
+		default:
+			recordNotification("dropped")
+			log.WithFields(log.Fields{
+				"url":   target.URL,
+				"event": notification.Event,
+			}).Warn("Notification queue is full, dropping notification")
+		}
+	}
+}
+
+// notificationQueue returns the queue for an endpoint, creating it and starting
+// its worker if this is the first notification sent to it.
+func notificationQueue(url string) chan queuedNotification {
+	notificationQueuesLock.Lock()
+	defer notificationQueuesLock.Unlock()
+
+	queue, ok := notificationQueues[url]
+	if !ok {
+		queue = make(chan queuedNotification, 256)
+		notificationQueues[url] = queue
+
+		if notifierStop != nil {
+			go deliverNotifications(queue, notifierStop)
+		}
+	}
+
+	return queue
+}
+
+func deliverNotifications(queue <-chan queuedNotification, stop <-chan struct{}) {
+	for {
+		select {
+		case q := <-queue:
+			if err := q.target.Send(q.notification); err != nil {
+				recordNotification("failed")
+				log.WithError(err).WithFields(log.Fields{
+					"url":   q.target.URL,
+					"event": q.notification.Event,
+				}).Error("Failed to send notification")
+				continue
+			}
+
+			recordNotification("sent")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// StartNotifier launches a background goroutine for each endpoint which
+// delivers its queued notifications. Closing the returned channel will stop
+// the notifier.
+func StartNotifier() chan<- struct{} {
+	stop := make(chan struct{})
+
+	notificationQueuesLock.Lock()
+	defer notificationQueuesLock.Unlock()
+
+	notifierStop = stop
+	for _, queue := range notificationQueues {
+		go deliverNotifications(queue, stop)
+	}
+
+	return stop
+}
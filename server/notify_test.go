@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifyDeadEndpointDoesNotBlockOthers(t *testing.T) {
+	release := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer dead.Close()
+	defer close(release)
+
+	received := make(chan Notification, 10)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := Notification{}
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+
+		received <- n
+	}))
+	defer healthy.Close()
+
+	cfg := DefaultConfig()
+	cfg.Notifications = []ConfigNotification{
+		{URL: dead.URL, Timeout: time.Minute},
+		{URL: healthy.URL},
+	}
+	defer setupTestServer(t, cfg)()
+	defer close(StartNotifier())
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		Notify(NewNotification("key_added", &AuditEvent{User: user}, nil))
+	}
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		select {
+		case n := <-received:
+			if n.User != user {
+				t.Errorf("expected a notification for %s but got one for %s", user, n.User)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the notification for %s to be delivered while the other endpoint is unresponsive", user)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+// ConfigPolicy restricts the keys which may be registered for a user.
+// Any field left at its zero value is not enforced.
+type ConfigPolicy struct {
+	MaxExpiry       time.Duration `yaml:"max_expiry"`
+	AllowedKeyTypes []string      `yaml:"allowed_key_types"`
+	MinRSABits      int           `yaml:"min_rsa_bits"`
+	MaxKeys         int           `yaml:"max_keys"`
+
+	// AllowedHosts and AllowedGroups restrict the hosts which keys may be
+	// scoped to, using shell patterns like "web-*". If either is set then
+	// keys must be scoped to specific hosts or groups.
+	AllowedHosts  []string `yaml:"allowed_hosts"`
+	AllowedGroups []string `yaml:"allowed_groups"`
+
+	// Signers replace AllowedHosts and AllowedGroups for the requests signed
+	// by particular keys.
+	Signers []ConfigSignerPolicy `yaml:"signers"`
+}
+
+// ConfigSignerPolicy restricts the hosts which keys may be scoped to when
+// they are requested by a specific signer, identified by its PGP key ID or
+// fingerprint, or the SHA256 fingerprint of its SSH signing key.
+type ConfigSignerPolicy struct {
+	Signer        string   `yaml:"signer"`
+	AllowedHosts  []string `yaml:"allowed_hosts"`
+	AllowedGroups []string `yaml:"allowed_groups"`
+}
+
+// Matches determines whether the request was signed by this signer, given
+// the signer's PGP key ID or SSH key fingerprint.
+func (s *ConfigSignerPolicy) Matches(signer string) bool {
+	if strings.HasPrefix(s.Signer, "SHA256:") {
+		return s.Signer == signer
+	}
+
+	// PGP fingerprints end with the key's ID
+	id := strings.ToUpper(strings.TrimPrefix(strings.Replace(s.Signer, " ", "", -1), "0x"))
+	return len(signer) == 16 && strings.HasSuffix(id, strings.ToUpper(signer))
+}
+
+// Validate ensures that the signer is identified precisely enough
+func (s *ConfigSignerPolicy) Validate() error {
+	if strings.HasPrefix(s.Signer, "SHA256:") {
+		return nil
+	}
+
+	id := strings.TrimPrefix(strings.Replace(s.Signer, " ", "", -1), "0x")
+	if _, err := hex.DecodeString(id); err != nil || (len(id) != 16 && len(id) != 40) {
+		return fmt.Errorf("the signer '%s' must be a long PGP key ID, a PGP fingerprint or an SSH key's SHA256 fingerprint", s.Signer)
+	}
+
+	return nil
+}
+
+// Validate ensures that the policy can be enforced
+func (p *ConfigPolicy) Validate() error {
+	for _, s := range p.Signers {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scopes returns the hosts and groups which the signer may scope keys to
+func (p *ConfigPolicy) scopes(signer string) (hosts, groups []string) {
+	for _, s := range p.Signers {
+		if s.Matches(signer) {
+			return s.AllowedHosts, s.AllowedGroups
+		}
+	}
+
+	return p.AllowedHosts, p.AllowedGroups
+}
+
+// Check determines whether a key may be added under this policy, given the
+// keys which are currently active for the user and the PGP key ID or SSH key
+// fingerprint of the request's signer. If the key is not permitted, the
+// returned error describes why.
+func (p *ConfigPolicy) Check(key *crypto.Key, active []crypto.Key, signer string) error {
+	if p.MaxExpiry > 0 && key.Expires.Sub(time.Now()) > p.MaxExpiry {
+		return fmt.Errorf("keys may not be valid for longer than %s", p.MaxExpiry)
+	}
+
+	keyType := key.Type()
+	if len(p.AllowedKeyTypes) > 0 {
+		allowed := false
+		for _, t := range p.AllowedKeyTypes {
+			if strings.EqualFold(t, keyType) {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("%s keys are not permitted, allowed key types are: %s", keyType, strings.Join(p.AllowedKeyTypes, ", "))
+		}
+	}
+
+	if p.MinRSABits > 0 && keyType == "rsa" && key.Bits() < p.MinRSABits {
+		return fmt.Errorf("RSA keys must be at least %d bits long, this key is %d bits long", p.MinRSABits, key.Bits())
+	}
+
+	allowedHosts, allowedGroups := p.scopes(signer)
+	if len(allowedHosts) > 0 || len(allowedGroups) > 0 {
+		if !key.Scoped() {
+			return fmt.Errorf("keys must be scoped to specific hosts or groups")
+		}
+
+		for _, h := range key.Hosts {
+			if !matchesAny(allowedHosts, h) {
+				return fmt.Errorf("keys may not be scoped to the host '%s'", h)
+			}
+		}
+
+		for _, g := range key.Groups {
+			if !matchesAny(allowedGroups, g) {
+				return fmt.Errorf("keys may not be scoped to the group '%s'", g)
+			}
+		}
+	}
+
+	if p.MaxKeys > 0 {
+		count := 0
+		for _, k := range active {
+			// Renewing an existing key doesn't increase the number of keys
+			if !k.Equals(key) {
+				count++
+			}
+		}
+
+		if count >= p.MaxKeys {
+			return fmt.Errorf("a maximum of %d keys may be active at any time", p.MaxKeys)
+		}
+	}
+
+	return nil
+}
+
+// matchesAny determines whether the value matches any of the shell patterns
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, value); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
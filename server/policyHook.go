@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigPolicyHook configures an external webhook which is consulted before
+// a key is accepted. If no URL is provided, the hook is not used.
+type ConfigPolicyHook struct {
+	URL     string        `yaml:"url"`
+	Secret  string        `yaml:"secret"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	// FailOpen allows keys to be accepted if the hook cannot be reached or
+	// doesn't respond with a decision, by default they are rejected.
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// PolicyHookRequest is the payload sent to the policy webhook
+type PolicyHookRequest struct {
+	Key    crypto.Key       `json:"key"`
+	Signer PolicyHookSigner `json:"signer"`
+	Client PolicyHookClient `json:"client"`
+}
+
+// PolicyHookSigner identifies the PGP key which signed the request
+type PolicyHookSigner struct {
+	KeyID string `json:"key_id"`
+	UID   string `json:"uid,omitempty"`
+}
+
+// PolicyHookClient describes the client which made the request
+type PolicyHookClient struct {
+	SourceIP  string `json:"source_ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// PolicyHookResponse is the decision returned by the policy webhook. The
+// expiry may only be shortened and options are added to those already on
+// the key.
+type PolicyHookResponse struct {
+	Allow   bool       `json:"allow"`
+	Reason  string     `json:"reason,omitempty"`
+	Expires *time.Time `json:"expire,omitempty"`
+	Options []string   `json:"options,omitempty"`
+}
+
+// Enabled determines whether a policy webhook has been configured
+func (h *ConfigPolicyHook) Enabled() bool {
+	return h.URL != ""
+}
+
+// Validate ensures that the hook's URL can be used
+func (h *ConfigPolicyHook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("the URL must use http or https")
+	}
+
+	return nil
+}
+
+// Signature computes the value of the X-Inki-Signature header for a payload
+// sent at the time given by the X-Inki-Timestamp header. The timestamp is
+// signed along with the payload so that the hook can refuse old requests
+// which are replayed to it.
+func (h *ConfigPolicyHook) Signature(timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Check asks the webhook whether the key may be added, returning its decision.
+// If the hook cannot be reached or doesn't give a usable answer and FailOpen
+// is set, the key is allowed without changes. A decision to deny the key is
+// always respected.
+func (h *ConfigPolicyHook) Check(r *PolicyHookRequest) (*PolicyHookResponse, error) {
+	res, err := h.call(r)
+	if err != nil {
+		if h.FailOpen {
+			log.WithError(err).WithField("url", h.URL).Warn("Policy webhook failed, allowing key as the hook fails open")
+			return &PolicyHookResponse{Allow: true}, nil
+		}
+
+		return nil, err
+	}
+
+	if !res.Allow {
+		return res, nil
+	}
+
+	// The options restrict the key, so allowing it without them would grant
+	// more than the hook asked for.
+	for _, o := range res.Options {
+		if err := crypto.ValidateOption(o); err != nil {
+			return nil, fmt.Errorf("the policy webhook returned invalid options: %s", err)
+		}
+	}
+
+	return res, nil
+}
+
+func (h *ConfigPolicyHook) call(r *PolicyHookRequest) (*PolicyHookResponse, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Inki-Timestamp", timestamp)
+		req.Header.Set("X-Inki-Signature", h.Signature(timestamp, payload))
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("the policy webhook responded with status %d", res.StatusCode)
+	}
+
+	var decision PolicyHookResponse
+	if err := json.NewDecoder(res.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("the policy webhook returned an invalid response: %s", err)
+	}
+
+	return &decision, nil
+}
+
+// Apply updates the key with the overrides returned by the webhook
+func (r *PolicyHookResponse) Apply(key *crypto.Key) {
+	if r.Expires != nil && r.Expires.Before(key.Expires) {
+		key.Expires = *r.Expires
+	}
+
+	if len(r.Options) > 0 {
+		key.Options = crypto.MergeOptions(key.Options, r.Options)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestPolicyHook(t *testing.T, response string) (*ConfigPolicyHook, func()) {
+	hook := &ConfigPolicyHook{Secret: "secret", FailOpen: true}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		timestamp := r.Header.Get("X-Inki-Timestamp")
+		if sent, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+			t.Errorf("expected a current timestamp but got '%s'", timestamp)
+		}
+
+		if sig := r.Header.Get("X-Inki-Signature"); sig != hook.Signature(timestamp, payload) {
+			t.Errorf("expected the signature to cover the timestamp and payload but got '%s'", sig)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+
+	hook.URL = srv.URL
+	return hook, srv.Close
+}
+
+func TestPolicyHookDenyWithInvalidOptions(t *testing.T) {
+	hook, cleanup := newTestPolicyHook(t, `{"allow": false, "reason": "no incident", "options": ["not an option"]}`)
+	defer cleanup()
+
+	res, err := hook.Check(&PolicyHookRequest{Key: *newTestKey(t, "root")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Allow {
+		t.Error("expected the hook's decision to deny the key to be respected")
+	}
+}
+
+func TestPolicyHookAllowWithInvalidOptions(t *testing.T) {
+	hook, cleanup := newTestPolicyHook(t, `{"allow": true, "options": ["not an option"]}`)
+	defer cleanup()
+
+	if res, err := hook.Check(&PolicyHookRequest{Key: *newTestKey(t, "root")}); err == nil {
+		t.Errorf("expected the key to be rejected when the hook's options are invalid but got %v", res)
+	}
+}
+
+func TestPolicyHookFailOpen(t *testing.T) {
+	hook, cleanup := newTestPolicyHook(t, `not json`)
+	defer cleanup()
+
+	res, err := hook.Check(&PolicyHookRequest{Key: *newTestKey(t, "root")})
+	if err != nil || !res.Allow {
+		t.Errorf("expected the key to be allowed when the hook fails open but got %v (err: %v)", res, err)
+	}
+
+	hook.FailOpen = false
+	if _, err := hook.Check(&PolicyHookRequest{Key: *newTestKey(t, "root")}); err == nil {
+		t.Error("expected the key to be rejected when the hook fails closed")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+func TestPolicyScopesBySigner(t *testing.T) {
+	p := &ConfigPolicy{
+		AllowedGroups: []string{"web"},
+		Signers: []ConfigSignerPolicy{
+			{
+				Signer:        "0x8E4C1F5A3B2D9C07",
+				AllowedGroups: []string{"web", "db"},
+			},
+			{
+				Signer:       "SHA256:x7g7sbvSDU0VrXvMcAGa8KqR39uXzlkFWOuBKsgZ5Xs",
+				AllowedHosts: []string{"db-*"},
+			},
+		},
+	}
+
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		signer  string
+		hosts   []string
+		groups  []string
+		allowed bool
+	}{
+		{"0123456789ABCDEF", nil, []string{"web"}, true},
+		{"0123456789ABCDEF", nil, []string{"db"}, false},
+		{"0123456789ABCDEF", nil, nil, false},
+		{"8E4C1F5A3B2D9C07", nil, []string{"db"}, true},
+		{"8E4C1F5A3B2D9C07", []string{"db-01"}, nil, false},
+		{"SHA256:x7g7sbvSDU0VrXvMcAGa8KqR39uXzlkFWOuBKsgZ5Xs", []string{"db-01"}, nil, true},
+		{"SHA256:x7g7sbvSDU0VrXvMcAGa8KqR39uXzlkFWOuBKsgZ5Xs", nil, []string{"web"}, false},
+	}
+
+	for _, c := range cases {
+		key := newTestKey(t, "root")
+		key.Hosts = c.hosts
+		key.Groups = c.groups
+
+		err := p.Check(key, []crypto.Key{}, c.signer)
+		if c.allowed && err != nil {
+			t.Errorf("expected %s to be able to scope a key to %v %v but got: %s", c.signer, c.hosts, c.groups, err)
+		} else if !c.allowed && err == nil {
+			t.Errorf("expected %s not to be able to scope a key to %v %v", c.signer, c.hosts, c.groups)
+		}
+	}
+}
+
+func TestPolicySignerMatchesFingerprint(t *testing.T) {
+	s := &ConfigSignerPolicy{Signer: "D2C1 8A6F 0B9E 4C37 5A1D  8E4C 1F5A 3B2D 9C07 11AA"}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Matches("1F5A3B2D9C0711AA") {
+		t.Error("expected the signer to match the key ID at the end of its fingerprint")
+	}
+
+	if s.Matches("0123456789ABCDEF") {
+		t.Error("expected the signer not to match another key ID")
+	}
+}
+
+func TestPolicySignerValidate(t *testing.T) {
+	for _, signer := range []string{"", "3B2D9C07", "alice@example.com", "0xZZZZZZZZZZZZZZZZ"} {
+		s := &ConfigSignerPolicy{Signer: signer}
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected '%s' not to be accepted as a signer", signer)
+		}
+	}
+}
+
+func TestPolicyMaxKeys(t *testing.T) {
+	p := &ConfigPolicy{MaxKeys: 1}
+	existing := newTestKey(t, "root")
+
+	if err := p.Check(newTestKey(t, "root"), []crypto.Key{*existing}, ""); err == nil {
+		t.Error("expected a second key to be rejected")
+	}
+
+	renewed := *existing
+	renewed.Expires = time.Now().Add(2 * time.Hour)
+	if err := p.Check(&renewed, []crypto.Key{*existing}, ""); err != nil {
+		t.Errorf("expected an existing key to be renewable but got: %s", err)
+	}
+}
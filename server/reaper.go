@@ -0,0 +1,56 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// StartReaper launches a background goroutine which removes keys from the
+// key store once they have been expired for longer than the grace period.
+// Closing the returned channel will stop the reaper.
+func StartReaper(interval, grace time.Duration) chan<- struct{} {
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ReapExpiredKeys(grace)
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return stop
+}
+
+// ReapExpiredKeys removes all keys which expired more than grace ago
+func ReapExpiredKeys(grace time.Duration) {
+	keys, err := RemoveKeyBy(KeyExpiredBefore(time.Now().Add(-grace)))
+	if err != nil {
+		log.WithError(err).Error("Failed to remove expired keys from the key store")
+		return
+	}
+
+	for _, k := range keys {
+		log.WithFields(log.Fields{
+			"event":       "key.expired",
+			"user":        k.User,
+			"fingerprint": k.Fingerprint(),
+			"expire":      k.Expires,
+		}).Info("Removed expired key")
+
+		ev := &AuditEvent{
+			Action:  "expire",
+			Outcome: "removed",
+		}
+		ev.SetKey(&k)
+		WriteAuditEvent(ev)
+		Notify(NewNotification("key.expired", ev, &k))
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ReloadConfig reads the configuration file and, if it is valid, atomically
+// replaces the configuration in use by the server with it.
+func ReloadConfig(file string) error {
+	c, err := ReadConfig(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).Error("Failed to reload configuration file, keeping the current configuration")
+		return err
+	}
+
+	current := GetConfig()
+	if current.Store != c.Store || current.Port != c.Port || current.Reaper != c.Reaper || current.Audit != c.Audit || current.TLS.Cert != c.TLS.Cert || current.TLS.Key != c.TLS.Key || current.TLS.ClientCA != c.TLS.ClientCA {
+		log.Warn("Changes to the port, TLS files, store, reaper and audit configuration will only take effect once the server is restarted")
+	}
+
+	SetConfig(c)
+	log.WithField("file", file).Info("Reloaded configuration file")
+	return nil
+}
+
+// ReloadConfigOnSignal reloads the configuration file whenever the server
+// receives a SIGHUP.
+func ReloadConfigOnSignal(file string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			log.Info("Received SIGHUP, reloading configuration")
+			ReloadConfig(file)
+		}
+	}()
+}
+
+// WatchConfig reloads the configuration file whenever it is changed on disk.
+// The file's directory is watched, rather than the file itself, so that
+// editors and tools which replace the file (like Kubernetes' ConfigMaps) are
+// handled correctly.
+func WatchConfig(file string) (*fsnotify.Watcher, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		// Changes usually arrive as a burst of events, so wait for them to
+		// settle before reloading.
+		var pending <-chan time.Time
+
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(e.Name) == file || filepath.Base(e.Name) == "..data" {
+					pending = time.After(500 * time.Millisecond)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.WithError(err).Warn("Error while watching configuration file")
+			case <-pending:
+				pending = nil
+				log.WithField("file", file).Info("Configuration file changed, reloading")
+				ReloadConfig(file)
+			}
+		}
+	}()
+
+	return watcher, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+// ConfigRequests controls how signed requests are checked for replays.
+// If no audience is configured, requests must be intended for an address
+// with this server's hostname.
+type ConfigRequests struct {
+	Audience  []string      `yaml:"audience"`
+	ClockSkew time.Duration `yaml:"clock_skew"`
+}
+
+// Check ensures that the claims were issued within the permitted clock
+// skew, for one of this server's audiences and have not been used before.
+func (c *ConfigRequests) Check(claims *crypto.Claims) error {
+	if claims.IssuedAt.IsZero() {
+		return fmt.Errorf("the request did not include an issued_at time")
+	}
+
+	if claims.Nonce == "" {
+		return fmt.Errorf("the request did not include a nonce")
+	}
+
+	now := time.Now()
+	if claims.IssuedAt.Before(now.Add(-c.ClockSkew)) || claims.IssuedAt.After(now.Add(c.ClockSkew)) {
+		return fmt.Errorf("the request was issued at %s, which is outside the permitted window of %s", claims.IssuedAt, c.ClockSkew)
+	}
+
+	if !c.allowsAudience(claims.Audience) {
+		return fmt.Errorf("the request was intended for '%s' rather than this server", claims.Audience)
+	}
+
+	// Nonces only need to be remembered until the request would be rejected
+	// for being outside of the clock skew window anyway.
+	if !nonces.Use(claims.Nonce, claims.IssuedAt.Add(c.ClockSkew)) {
+		return fmt.Errorf("the request has already been used")
+	}
+
+	return nil
+}
+
+func (c *ConfigRequests) allowsAudience(audience string) bool {
+	for _, a := range c.Audience {
+		if a == audience {
+			return true
+		}
+	}
+
+	if len(c.Audience) > 0 {
+		return false
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return false
+	}
+
+	return strings.EqualFold(audienceHost(audience), hostname)
+}
+
+// audienceHost returns the name of the host from an audience, which is
+// usually the address of the server the request was sent to.
+func audienceHost(audience string) string {
+	u, err := url.Parse(audience)
+	if err != nil || u.Host == "" {
+		return audience
+	}
+
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		return host
+	}
+
+	return u.Host
+}
+
+var nonces = &nonceCache{
+	nonces: map[string]time.Time{},
+}
+
+type nonceCache struct {
+	nonces map[string]time.Time
+	lock   sync.Mutex
+}
+
+// Use records the nonce as having been used until it expires, returning
+// false if it has already been used.
+func (c *nonceCache) Use(nonce string, expires time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for n, exp := range c.nonces {
+		if exp.Before(now) {
+			delete(c.nonces, n)
+		}
+	}
+
+	if _, used := c.nonces[nonce]; used {
+		return false
+	}
+
+	c.nonces[nonce] = expires
+	return true
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+)
+
+func TestRequestsCheckAudience(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("the hostname is not available")
+	}
+
+	cases := []struct {
+		audience []string
+		claimed  string
+		allowed  bool
+	}{
+		{nil, "https://" + hostname + ":3000", true},
+		{nil, "http://" + hostname, true},
+		{nil, hostname, true},
+		{nil, "https://not-" + hostname, false},
+		{nil, "", false},
+		{[]string{"https://inki.example.com"}, "https://inki.example.com", true},
+		{[]string{"https://inki.example.com"}, "https://" + hostname, false},
+	}
+
+	for _, c := range cases {
+		cfg := ConfigRequests{Audience: c.audience, ClockSkew: time.Minute}
+		claims, err := crypto.NewClaims(c.claimed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = cfg.Check(claims)
+		if c.allowed && err != nil {
+			t.Errorf("expected a request for '%s' to be accepted with the audience %v but got: %s", c.claimed, c.audience, err)
+		} else if !c.allowed && err == nil {
+			t.Errorf("expected a request for '%s' to be rejected with the audience %v", c.claimed, c.audience)
+		}
+	}
+}
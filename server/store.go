@@ -1,14 +1,79 @@
 package server
 
 import (
-	"sync"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/SierraSoftworks/inki/crypto"
+	"golang.org/x/crypto/ssh"
 )
 
-var keyStore []crypto.Key
-var keyStoreLock sync.Mutex
+// KeyStore is implemented by the various backends which Inki is able to
+// use to hold the keys which have been registered with it.
+type KeyStore interface {
+	// HasKey determines whether an equivalent key is present in the store
+	HasKey(key *crypto.Key) (bool, error)
+
+	// AddKey adds a key to the store, or updates the existing entry if an
+	// equivalent key is already present.
+	AddKey(key *crypto.Key) error
+
+	// GetKeysBy returns all of the keys which match the given predicate
+	GetKeysBy(pred KeyPredicate) ([]crypto.Key, error)
+
+	// GetKeysByFingerprint returns the user's keys with the given MD5 or
+	// SHA256:... fingerprint, using an index rather than scanning every key.
+	GetKeysByFingerprint(user, fingerprint string) ([]crypto.Key, error)
+
+	// RemoveKeyBy removes all keys which match the given predicate and
+	// returns the keys which were removed.
+	RemoveKeyBy(pred KeyPredicate) ([]crypto.Key, error)
+
+	// RevokeCertificate records that a certificate issued by the CA should no
+	// longer be accepted, replacing any existing entry for it. Entries which
+	// have expired may be discarded.
+	RevokeCertificate(cert *RevokedCertificate) error
+
+	// GetRevokedCertificates returns the revoked certificates which have not
+	// yet expired.
+	GetRevokedCertificates() ([]RevokedCertificate, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// RevokedCertificate identifies a certificate which was issued for a key that
+// has since been removed or replaced, it is kept until the certificate expires.
+type RevokedCertificate struct {
+	CA      string    `json:"ca"`
+	Serial  uint64    `json:"serial"`
+	KeyID   string    `json:"key_id"`
+	Expires time.Time `json:"expire"`
+}
+
+var store KeyStore
+
+func init() {
+	store = NewMemoryKeyStore()
+}
+
+// GetStore returns the key store which is currently in use by the server
+func GetStore() KeyStore {
+	return store
+}
+
+// SetStore replaces the key store used by the server, closing the previous one
+func SetStore(s KeyStore) error {
+	old := store
+	store = s
+
+	if old != nil {
+		return old.Close()
+	}
+
+	return nil
+}
 
 type KeyPredicate func(k *crypto.Key) bool
 
@@ -24,84 +89,128 @@ func (p KeyPredicate) Or(pred KeyPredicate) KeyPredicate {
 	}
 }
 
-func init() {
-	keyStore = []crypto.Key{}
+func HasKey(key *crypto.Key) (bool, error) {
+	return store.HasKey(key)
 }
 
-func HasKey(key *crypto.Key) bool {
-	keyStoreLock.Lock()
-	defer keyStoreLock.Unlock()
+func AddKey(key *crypto.Key) error {
+	// Replacing a key's certificate must not leave the old one usable, its
+	// options or expiry may differ from the new one's.
+	existing, err := store.GetKeysByFingerprint(key.User, key.Fingerprint())
+	if err != nil {
+		return err
+	}
 
-	for _, k := range keyStore {
-		if k.Equals(key) {
-			return true
+	for i := range existing {
+		if existing[i].Certificate != key.Certificate {
+			if err := revokeCertificate(&existing[i]); err != nil {
+				return err
+			}
 		}
 	}
 
-	return false
+	if err := store.AddKey(key); err != nil {
+		return err
+	}
+
+	recordChange("added", key)
+	return nil
 }
 
-func AddKey(key *crypto.Key) {
-	keyStoreLock.Lock()
-	defer keyStoreLock.Unlock()
+func GetAllKeys() ([]crypto.Key, error) {
+	return store.GetKeysBy(AnyKey())
+}
 
-	for i, k := range keyStore {
-		if k.Equals(key) {
-			// Update the expiry time
-			k.Expires = key.Expires
-			keyStore[i] = k
-			return
-		}
+func GetKeyBy(pred KeyPredicate) (*crypto.Key, error) {
+	keys, err := store.GetKeysBy(pred)
+	if err != nil {
+		return nil, err
 	}
 
-	keyStore = append(keyStore, *key)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return &keys[0], nil
+}
+
+func GetKeysBy(pred KeyPredicate) ([]crypto.Key, error) {
+	return store.GetKeysBy(pred)
 }
 
-func GetAllKeys() []crypto.Key {
-	return keyStore
+func GetKeysByFingerprint(user, fingerprint string) ([]crypto.Key, error) {
+	return store.GetKeysByFingerprint(user, crypto.NormalizeFingerprint(fingerprint))
 }
 
-func GetKeyBy(pred KeyPredicate) *crypto.Key {
-	keyStoreLock.Lock()
-	defer keyStoreLock.Unlock()
+func RemoveKey(key *crypto.Key) ([]crypto.Key, error) {
+	return RemoveKeyBy(KeyEquals(key))
+}
+
+func RemoveKeyBy(pred KeyPredicate) ([]crypto.Key, error) {
+	// Certificates are revoked before their keys are removed, so that a
+	// failure can't leave a removed key's certificate usable.
+	matching, err := store.GetKeysBy(pred)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, k := range keyStore {
-		if pred(&k) {
-			return &k
+	for i := range matching {
+		if err := revokeCertificate(&matching[i]); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	keys, err := store.RemoveKeyBy(pred)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		recordChange("removed", &keys[i])
+	}
+
+	return keys, nil
 }
 
-func GetKeysBy(pred KeyPredicate) []crypto.Key {
-	keyStoreLock.Lock()
-	defer keyStoreLock.Unlock()
+// GetRevokedCertificates returns the certificates which hosts should no longer
+// accept, because their keys were removed or replaced before they expired.
+func GetRevokedCertificates() ([]RevokedCertificate, error) {
+	return store.GetRevokedCertificates()
+}
 
-	results := []crypto.Key{}
-	for _, k := range keyStore {
-		if pred(&k) {
-			results = append(results, k)
-		}
+// revokeCertificate records the key's certificate as revoked if it has one
+// which has not yet expired.
+func revokeCertificate(k *crypto.Key) error {
+	if k.Certificate == "" {
+		return nil
 	}
 
-	return results
-}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(k.Certificate))
+	if err != nil {
+		return err
+	}
 
-func RemoveKey(key *crypto.Key) {
-	RemoveKeyBy(func(k *crypto.Key) bool {
-		return k.Equals(key)
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("the certificate for key %s is not valid", k.Fingerprint())
+	}
+
+	expires := time.Unix(int64(cert.ValidBefore), 0).UTC()
+	if !expires.After(time.Now()) {
+		return nil
+	}
+
+	return store.RevokeCertificate(&RevokedCertificate{
+		CA:      strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert.SignatureKey))),
+		Serial:  cert.Serial,
+		KeyID:   cert.KeyId,
+		Expires: expires,
 	})
 }
 
-func RemoveKeyBy(pred KeyPredicate) {
-	keyStoreLock.Lock()
-	defer keyStoreLock.Unlock()
-
-	for i, k := range keyStore {
-		if pred(&k) {
-			keyStore = append(keyStore[:i-1], keyStore[i+1:]...)
-		}
+func AnyKey() KeyPredicate {
+	return func(k *crypto.Key) bool {
+		return true
 	}
 }
 
@@ -123,14 +232,40 @@ func KeyExpired() KeyPredicate {
 	}
 }
 
+func KeyExpiredBefore(t time.Time) KeyPredicate {
+	return func(k *crypto.Key) bool {
+		return k.Expires.Before(t)
+	}
+}
+
 func UserEquals(user string) KeyPredicate {
 	return func(k *crypto.Key) bool {
 		return k.User == user
 	}
 }
 
+func KeyAppliesTo(host string, groups []string) KeyPredicate {
+	return func(k *crypto.Key) bool {
+		return k.AppliesTo(host, groups)
+	}
+}
+
+// fingerprintIndexKeys returns the entries under which a key is indexed by
+// the stores, one for each of its fingerprint formats.
+func fingerprintIndexKeys(k *crypto.Key) []string {
+	return []string{
+		fingerprintIndexKey(k.User, k.Fingerprint()),
+		fingerprintIndexKey(k.User, k.FingerprintMD5()),
+	}
+}
+
+func fingerprintIndexKey(user, fingerprint string) string {
+	return fmt.Sprintf("%s\x00%s", user, fingerprint)
+}
+
+// FingerprintEquals matches keys with either their SHA256 or MD5 fingerprint
 func FingerprintEquals(fingerprint string) KeyPredicate {
 	return func(k *crypto.Key) bool {
-		return k.Fingerprint() == fingerprint
+		return k.MatchesFingerprint(fingerprint)
 	}
 }
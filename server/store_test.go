@@ -0,0 +1,306 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SierraSoftworks/inki/crypto"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestKey generates a new ed25519 key for the user, valid for an hour
+func newTestKey(t *testing.T, user string) *crypto.Key {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &crypto.Key{
+		User:      user,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))),
+		Expires:   time.Now().Add(time.Hour).UTC(),
+	}
+}
+
+func newTestBoltKeyStore(t *testing.T) (*BoltKeyStore, func()) {
+	dir, err := ioutil.TempDir("", "inki-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewBoltKeyStore(filepath.Join(dir, "keys.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// keyStoreBackends runs a test against each of the key store backends
+func keyStoreBackends(t *testing.T, test func(t *testing.T, s KeyStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryKeyStore())
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		s, cleanup := newTestBoltKeyStore(t)
+		defer cleanup()
+
+		test(t, s)
+	})
+}
+
+func assertKeys(t *testing.T, keys []crypto.Key, err error, expected ...*crypto.Key) {
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(keys) != len(expected) {
+		t.Fatalf("expected %d keys but got %d: %v", len(expected), len(keys), keys)
+	}
+
+	for _, e := range expected {
+		found := false
+		for _, k := range keys {
+			if k.Equals(e) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("expected key %s for %s to be returned", e.Fingerprint(), e.User)
+		}
+	}
+}
+
+func TestKeyStoreAddKey(t *testing.T) {
+	keyStoreBackends(t, func(t *testing.T, s KeyStore) {
+		k := newTestKey(t, "alice")
+		if err := s.AddKey(k); err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := s.HasKey(k); err != nil || !ok {
+			t.Fatalf("expected the key to be present (err: %v)", err)
+		}
+
+		if ok, _ := s.HasKey(newTestKey(t, "alice")); ok {
+			t.Error("expected an unknown key not to be present")
+		}
+
+		keys, err := s.GetKeysBy(UserEquals("alice"))
+		assertKeys(t, keys, err, k)
+	})
+}
+
+func TestKeyStoreAddKeyUpdatesExisting(t *testing.T) {
+	keyStoreBackends(t, func(t *testing.T, s KeyStore) {
+		k := newTestKey(t, "alice")
+		if err := s.AddKey(k); err != nil {
+			t.Fatal(err)
+		}
+
+		updated := *k
+		updated.Expires = k.Expires.Add(time.Hour)
+		if err := s.AddKey(&updated); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, err := s.GetKeysBy(AnyKey())
+		assertKeys(t, keys, err, k)
+		if !keys[0].Expires.Equal(updated.Expires) {
+			t.Errorf("expected the expiry to be updated to %s but got %s", updated.Expires, keys[0].Expires)
+		}
+
+		keys, err = s.GetKeysByFingerprint("alice", k.Fingerprint())
+		assertKeys(t, keys, err, k)
+		if !keys[0].Expires.Equal(updated.Expires) {
+			t.Errorf("expected the indexed key to have the updated expiry %s but got %s", updated.Expires, keys[0].Expires)
+		}
+	})
+}
+
+func TestKeyStoreGetKeysByFingerprint(t *testing.T) {
+	keyStoreBackends(t, func(t *testing.T, s KeyStore) {
+		alice := newTestKey(t, "alice")
+		bob := newTestKey(t, "bob")
+		for _, k := range []*crypto.Key{alice, bob} {
+			if err := s.AddKey(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys, err := s.GetKeysByFingerprint("alice", alice.Fingerprint())
+		assertKeys(t, keys, err, alice)
+
+		keys, err = s.GetKeysByFingerprint("alice", alice.FingerprintMD5())
+		assertKeys(t, keys, err, alice)
+
+		// Keys are only found for the user they belong to
+		keys, err = s.GetKeysByFingerprint("bob", alice.Fingerprint())
+		assertKeys(t, keys, err)
+
+		keys, err = s.GetKeysByFingerprint("alice", bob.Fingerprint())
+		assertKeys(t, keys, err)
+	})
+}
+
+func TestKeyStoreRemoveKeyBy(t *testing.T) {
+	keyStoreBackends(t, func(t *testing.T, s KeyStore) {
+		first := newTestKey(t, "alice")
+		second := newTestKey(t, "alice")
+		third := newTestKey(t, "alice")
+		for _, k := range []*crypto.Key{first, second, third} {
+			if err := s.AddKey(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		removed, err := s.RemoveKeyBy(KeyEquals(first))
+		assertKeys(t, removed, err, first)
+
+		keys, err := s.GetKeysByFingerprint("alice", first.Fingerprint())
+		assertKeys(t, keys, err)
+
+		keys, err = s.GetKeysByFingerprint("alice", first.FingerprintMD5())
+		assertKeys(t, keys, err)
+
+		// The remaining keys must still be found through the index
+		keys, err = s.GetKeysByFingerprint("alice", second.Fingerprint())
+		assertKeys(t, keys, err, second)
+
+		keys, err = s.GetKeysByFingerprint("alice", third.FingerprintMD5())
+		assertKeys(t, keys, err, third)
+
+		removed, err = s.RemoveKeyBy(UserEquals("bob"))
+		assertKeys(t, removed, err)
+	})
+}
+
+func TestBoltKeyStorePersistsKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inki-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.db")
+	s, err := NewBoltKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newTestKey(t, "alice")
+	if err := s.AddKey(k); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewBoltKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	keys, err := s.GetKeysByFingerprint("alice", k.Fingerprint())
+	assertKeys(t, keys, err, k)
+}
+
+func TestBoltKeyStoreBuildsIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inki-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a database in the format used before the fingerprint index
+	path := filepath.Join(dir, "keys.db")
+	k := newTestKey(t, "alice")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(boltKeysBucket)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(boltKeyID(k), data)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewBoltKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	keys, err := s.GetKeysByFingerprint("alice", k.Fingerprint())
+	assertKeys(t, keys, err, k)
+
+	keys, err = s.GetKeysByFingerprint("alice", k.FingerprintMD5())
+	assertKeys(t, keys, err, k)
+}
+
+func TestKeyStoreRevokeCertificate(t *testing.T) {
+	keyStoreBackends(t, func(t *testing.T, s KeyStore) {
+		expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		certs := []*RevokedCertificate{
+			{CA: "ca", Serial: 1, KeyID: "alice:first", Expires: expires},
+			{CA: "ca", Serial: 2, KeyID: "alice:second", Expires: expires},
+			{CA: "ca", Serial: 1, KeyID: "alice:first", Expires: expires.Add(time.Hour)},
+			{CA: "ca", Serial: 3, KeyID: "alice:expired", Expires: time.Now().Add(-time.Minute)},
+		}
+
+		for _, c := range certs {
+			if err := s.RevokeCertificate(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		revoked, err := s.GetRevokedCertificates()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		serials := map[uint64]time.Time{}
+		for _, r := range revoked {
+			serials[r.Serial] = r.Expires
+		}
+
+		if len(revoked) != 2 || len(serials) != 2 {
+			t.Fatalf("expected the two unexpired certificates to be revoked but got %v", revoked)
+		}
+
+		if !serials[1].Equal(expires.Add(time.Hour)) {
+			t.Errorf("expected the revocation to be updated to expire at %s but got %s", expires.Add(time.Hour), serials[1])
+		}
+	})
+}
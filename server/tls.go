@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigTLS enables HTTPS for the server. If a client CA is provided then
+// clients may authenticate using certificates issued by it, and setting
+// RequireClientCert will require them to do so for the read endpoints.
+type ConfigTLS struct {
+	Cert              string `yaml:"cert"`
+	Key               string `yaml:"key"`
+	ClientCA          string `yaml:"client_ca"`
+	RequireClientCert bool   `yaml:"require_client_cert"`
+}
+
+// Enabled determines whether the server should serve HTTPS
+func (c *ConfigTLS) Enabled() bool {
+	return c.Cert != "" && c.Key != ""
+}
+
+// TLSConfig builds the TLS configuration used by the server. The server's
+// certificate is reloaded from disk whenever it changes.
+func (c *ConfigTLS) TLSConfig() (*tls.Config, error) {
+	certs := &certificateReloader{
+		certFile: c.Cert,
+		keyFile:  c.Key,
+	}
+
+	if _, err := certs.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if c.ClientCA != "" {
+		data, err := ioutil.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates could be read from the client CA file '%s'", c.ClientCA)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
+
+// certificateReloader loads a certificate and key from disk, reloading them
+// whenever either file is modified so that certificates can be rotated
+// without restarting the server.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func (r *certificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	modified, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			log.WithError(err).Warn("Failed to check TLS certificate for changes, using the existing certificate")
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	if r.cert != nil && !modified.After(r.modified) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			log.WithError(err).Error("Failed to reload TLS certificate, using the existing certificate")
+			return r.cert, nil
+		}
+
+		return nil, err
+	}
+
+	if r.cert != nil {
+		log.WithField("file", r.certFile).Info("Reloaded TLS certificate")
+	}
+
+	r.cert = &cert
+	r.modified = modified
+	return r.cert, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	latest := time.Time{}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
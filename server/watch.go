@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SierraSoftworks/girder"
+	"github.com/SierraSoftworks/girder/errors"
+	"github.com/SierraSoftworks/inki/crypto"
+	log "github.com/Sirupsen/logrus"
+)
+
+// WatchResponse is returned by the long-poll watch API. If Reset is set then
+// some changes were missed and the client should fetch all keys again before
+// watching from the given revision.
+type WatchResponse struct {
+	Revision uint64      `json:"revision"`
+	Reset    bool        `json:"reset,omitempty"`
+	Changes  []KeyChange `json:"changes"`
+}
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+	watchKeepAlive      = 15 * time.Second
+)
+
+func init() {
+	Router().
+		Path("/v1/watch").
+		Methods("GET").
+		HeadersRegexp("Accept", "text/event-stream").
+		Handler(http.HandlerFunc(streamChanges)).
+		Name("GET /watch (stream)")
+
+	Router().
+		Path("/v1/watch").
+		Methods("GET").
+		Handler(girder.NewHandler(pollChanges)).
+		Name("GET /watch")
+}
+
+// watchFilter selects the changes which the client is permitted to see,
+// optionally limited to a single user, and rewrites those for keys which do
+// not apply to it.
+type watchFilter struct {
+	readable KeyPredicate
+	applies  KeyPredicate
+}
+
+func newWatchFilter(r *http.Request, host *ConfigHost) *watchFilter {
+	readable := KeyPredicate(func(k *crypto.Key) bool {
+		return host.CanRead(k.User)
+	})
+
+	if user := r.URL.Query().Get("user"); user != "" {
+		readable = readable.And(UserEquals(user))
+	}
+
+	// Hosts which cannot be identified are only given keys which have not
+	// been scoped to specific hosts or groups.
+	name, groups := requestingHost(r, host)
+
+	return &watchFilter{
+		readable: readable,
+		applies:  KeyAppliesTo(name, groups),
+	}
+}
+
+// Filter determines whether the client should see the change. A key which
+// doesn't apply to the client is sent as being removed, since it may have
+// applied before it was rescoped and the client would otherwise keep it.
+func (f *watchFilter) Filter(c KeyChange) (KeyChange, bool) {
+	if !f.readable(&c.Key) {
+		return c, false
+	}
+
+	if !f.applies(&c.Key) {
+		c.Type = "removed"
+		c.Key = crypto.Key{
+			User:      c.Key.User,
+			PublicKey: c.Key.PublicKey,
+			Expires:   c.Key.Expires,
+		}
+		return c, true
+	}
+
+	c.Key = withUserOptions([]crypto.Key{c.Key})[0]
+	return c, true
+}
+
+func filterChanges(entries []KeyChange, filter *watchFilter) []KeyChange {
+	filtered := []KeyChange{}
+	for _, c := range entries {
+		if c, ok := filter.Filter(c); ok {
+			filtered = append(filtered, c)
+		}
+	}
+
+	return filtered
+}
+
+func pollChanges(c *girder.Context) (interface{}, error) {
+	host, err := authenticateHost(c.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := newWatchFilter(c.Request, host)
+
+	q := c.Request.URL.Query()
+	if q.Get("since") == "" {
+		return &WatchResponse{
+			Revision: CurrentRevision(),
+			Changes:  []KeyChange{},
+		}, nil
+	}
+
+	since, err := strconv.ParseUint(q.Get("since"), 10, 64)
+	if err != nil {
+		return nil, errors.BadRequest()
+	}
+
+	timeout := defaultWatchTimeout
+	if q.Get("timeout") != "" {
+		timeout, err = time.ParseDuration(q.Get("timeout"))
+		if err != nil || timeout < 0 {
+			return nil, errors.BadRequest()
+		}
+
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+	}
+
+	deadline := time.After(timeout)
+	for {
+		entries, ok, next := GetChangesSince(since)
+		if !ok {
+			return &WatchResponse{
+				Revision: CurrentRevision(),
+				Reset:    true,
+				Changes:  []KeyChange{},
+			}, nil
+		}
+
+		if len(entries) > 0 {
+			since = entries[len(entries)-1].Revision
+		}
+
+		if filtered := filterChanges(entries, filter); len(filtered) > 0 {
+			return &WatchResponse{
+				Revision: since,
+				Changes:  filtered,
+			}, nil
+		}
+
+		select {
+		case <-next:
+		case <-deadline:
+			return &WatchResponse{
+				Revision: since,
+				Changes:  []KeyChange{},
+			}, nil
+		case <-c.Request.Context().Done():
+			return nil, nil
+		}
+	}
+}
+
+// streamChanges sends changes to the client as Server-Sent Events, starting
+// from the revision given by the since parameter or the Last-Event-ID header.
+func streamChanges(w http.ResponseWriter, r *http.Request) {
+	host, err := authenticateHost(r)
+	if err != nil {
+		girder.NewHandler(func(c *girder.Context) (interface{}, error) {
+			return nil, err
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	filter := newWatchFilter(r, host)
+
+	since := CurrentRevision()
+	lastID := r.URL.Query().Get("since")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		lastID = id
+	}
+
+	if lastID != "" {
+		since, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			girder.NewHandler(func(c *girder.Context) (interface{}, error) {
+				return nil, errors.BadRequest()
+			}).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error("Response writer does not support streaming")
+		girder.NewHandler(func(c *girder.Context) (interface{}, error) {
+			return nil, errors.ServerError()
+		}).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		entries, ok, next := GetChangesSince(since)
+		if !ok {
+			since = CurrentRevision()
+			fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"revision\":%d}\n\n", since, since)
+			flusher.Flush()
+			continue
+		}
+
+		for _, c := range entries {
+			since = c.Revision
+			c, ok := filter.Filter(c)
+			if !ok {
+				continue
+			}
+
+			data, err := json.Marshal(c)
+			if err != nil {
+				log.WithError(err).Error("Failed to encode key change")
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.Revision, c.Type, data)
+		}
+		flusher.Flush()
+
+		select {
+		case <-next:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}